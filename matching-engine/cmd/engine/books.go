@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/opencode-exchange/matching-engine/internal/kafka"
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+	"github.com/opencode-exchange/matching-engine/internal/wire"
+	"go.uber.org/zap"
+)
+
+// bookSnapshots publishes full books to the compacted snapshots topic. A
+// consumer that starts late or misses a delta rebuilds a book from its
+// latest snapshot and the deltas with a higher sequence.
+//
+// Snapshots are read from the books' published views, so taking one never
+// waits on matching. They are not journaled: they can always be taken again.
+// Each is stamped with the time of the last command its book ran.
+type bookSnapshots struct {
+	matcher  *matcher.Matcher
+	clock    matcher.Clock
+	producer *kafka.Producer
+	logger   *zap.Logger
+
+	// mu orders publishes, so the topic never moves a symbol back to an
+	// older view. published is the view last sent per symbol.
+	mu        sync.Mutex
+	published map[string]*orderbook.View
+}
+
+func newBookSnapshots(m *matcher.Matcher, clock matcher.Clock, producer *kafka.Producer, logger *zap.Logger) *bookSnapshots {
+	return &bookSnapshots{
+		matcher:   m,
+		clock:     clock,
+		producer:  producer,
+		logger:    logger,
+		published: make(map[string]*orderbook.View),
+	}
+}
+
+// run publishes every book that changed since its last snapshot, at start
+// and then every interval, until ctx is done. A failed round is logged and
+// left to the next.
+func (s *bookSnapshots) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.publish(ctx, "", false, s.producer.PublishMessages); err != nil && ctx.Err() == nil {
+			s.logger.Error("Failed to publish book snapshots", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish sends symbol's book, or every book if symbol is empty, with send.
+// Unless force is set, books that have not changed since their last snapshot
+// are skipped.
+func (s *bookSnapshots) publish(ctx context.Context, symbol string, force bool, send func(context.Context, []kafka.OutboundMessage) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Views are loaded under the lock, so none is older than one already
+	// sent.
+	views := s.matcher.Views()
+	if symbol != "" {
+		views = []*orderbook.View{s.matcher.View(symbol)}
+	}
+
+	var msgs []kafka.OutboundMessage
+	var sent []*orderbook.View
+	for _, view := range views {
+		if !force && s.published[view.Symbol] == view {
+			continue
+		}
+
+		bids, asks := view.Depth(max(view.Bids.Len(), view.Asks.Len()))
+		value, err := json.Marshal(&kafka.OrderbookSnapshotEvent{
+			Symbol:    view.Symbol,
+			Sequence:  view.Sequence,
+			Bids:      wire.FormatLevels(view.Scale, bids),
+			Asks:      wire.FormatLevels(view.Scale, asks),
+			Timestamp: s.clock.Now(view.Symbol).UnixMilli(),
+		})
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.OutboundMessage{Topic: kafka.TopicOrderbookSnapshots, Key: view.Symbol, Value: value})
+		sent = append(sent, view)
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	if err := send(ctx, msgs); err != nil {
+		return err
+	}
+	for _, view := range sent {
+		s.published[view.Symbol] = view
+	}
+	return nil
+}
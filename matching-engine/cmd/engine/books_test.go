@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/opencode-exchange/matching-engine/internal/kafka"
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+	"go.uber.org/zap"
+)
+
+func TestSnapshotRetriedWithCommandTime(t *testing.T) {
+	clock := matcher.NewCommandClock()
+	m := matcher.NewMatcher(clock, nil)
+	m.AddMarket(matcher.MarketRules{Symbol: "BTC/USDT", Scale: orderbook.DefaultScale})
+	m.ProcessOrder(orderbook.NewOrder("b1", "u1", "BTC/USDT", orderbook.Buy, orderbook.Limit, 99, 1))
+	ran := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock.Set("BTC/USDT", ran)
+
+	w := &fakeWriter{fails: 2}
+	producer := kafka.NewProducerWithWriters(map[string]kafka.Writer{kafka.TopicOrderbookSnapshots: w}, zap.NewNop())
+	e := &engine{producer: producer, logger: zap.NewNop()}
+	s := newBookSnapshots(m, clock, producer, zap.NewNop())
+	e.snapshots = s
+
+	if err := s.publish(context.Background(), "BTC/USDT", true, e.publish); err != nil {
+		t.Fatal(err)
+	}
+	if w.calls != 3 || len(w.written) != 1 {
+		t.Fatalf("%d snapshots written in %d calls, want 1 on the third", len(w.written), w.calls)
+	}
+	var snapshot kafka.OrderbookSnapshotEvent
+	if err := json.Unmarshal([]byte(w.written[0]), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Symbol != "BTC/USDT" || snapshot.Timestamp != ran.UnixMilli() || len(snapshot.Bids) != 1 {
+		t.Fatalf("got %+v, want BTC/USDT with one bid stamped %d", snapshot, ran.UnixMilli())
+	}
+
+	// A round that fails is not recorded as sent, so the next round sends
+	// the changed book again and the one after has nothing to send.
+	m.ProcessOrder(orderbook.NewOrder("b2", "u1", "BTC/USDT", orderbook.Buy, orderbook.Limit, 98, 1))
+	w.fails, w.calls, w.written = 1, 0, nil
+	if err := s.publish(context.Background(), "", false, producer.PublishMessages); err == nil {
+		t.Fatal("failed write reported as sent")
+	}
+	if err := s.publish(context.Background(), "", false, producer.PublishMessages); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.publish(context.Background(), "", false, producer.PublishMessages); err != nil {
+		t.Fatal(err)
+	}
+	if w.calls != 2 || len(w.written) != 1 {
+		t.Fatalf("%d snapshots written in %d calls, want 1 on the second", len(w.written), w.calls)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/opencode-exchange/matching-engine/internal/command"
+	"github.com/opencode-exchange/matching-engine/internal/journal"
+	"github.com/opencode-exchange/matching-engine/internal/kafka"
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+	"github.com/opencode-exchange/matching-engine/internal/wire"
+	"go.uber.org/zap"
+)
+
+const publishRetryDelay = 500 * time.Millisecond
+
+// engine applies commands to the matcher exactly once. Every command ID is
+// remembered, and the messages a command produces are journaled before they
+// are published. A command that is delivered again is not applied again; if
+// its offset was never committed its journaled messages are published again
+// instead, so nothing is lost. A failed publish is retried with only the
+// messages Kafka did not take, so retries double nothing. Only a crash
+// between publishing and committing the offset can send a command's
+// messages twice.
+//
+// Commands for a single book run concurrently on the consumer's workers.
+// schedule, which sees every command in order, admits them to the processor
+// and picks the commands that must run alone.
+type engine struct {
+	processor *command.Processor
+	matcher   *matcher.Matcher
+	producer  *kafka.Producer
+	journal   *journal.Journal
+	logger    *zap.Logger
+
+	snapshots *bookSnapshots
+
+	mu         sync.Mutex
+	admissions map[kafka.Position]*admission
+
+	// While replaying commands that were already handled before a restart the
+	// books are rebuilt but nothing is published again.
+	replaying bool
+
+	// pending holds journaled commands whose offsets were not committed before
+	// the restart. Their messages may not have reached Kafka.
+	pending map[string]*journal.Entry
+}
+
+// admission is what schedule decided about a command that needs more than
+// running it. If a command whose heartbeats expired is itself dead-lettered,
+// the cancellations are held in expired to go out with the dead letter.
+type admission struct {
+	command.Admission
+	expired []*matcher.MatchResult
+}
+
+// schedule runs for every command in the order it was read and reports
+// whether the command must run alone.
+func (e *engine) schedule(key []byte, cmd *kafka.OrderCommand, pos kafka.Position) bool {
+	adm := e.processor.Admit(key, cmd)
+	if adm.Duplicate || len(adm.Expiring) > 0 {
+		e.admit(pos, &admission{Admission: adm})
+	}
+	return adm.Exclusive
+}
+
+func (e *engine) admit(pos kafka.Position, a *admission) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.admissions == nil {
+		e.admissions = make(map[kafka.Position]*admission)
+	}
+	e.admissions[pos] = a
+}
+
+// admission removes and returns what schedule decided about the command at
+// pos.
+func (e *engine) admission(pos kafka.Position) *admission {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if a, ok := e.admissions[pos]; ok {
+		delete(e.admissions, pos)
+		return a
+	}
+	return &admission{}
+}
+
+func (e *engine) handle(ctx context.Context, cmd *kafka.OrderCommand, pos kafka.Position) error {
+	adm := e.admission(pos)
+	if adm.Duplicate {
+		if e.replaying {
+			return nil
+		}
+
+		if entry, ok := e.pending[cmd.CommandID]; ok {
+			e.logger.Info("Re-publishing journaled command",
+				zap.String("commandId", cmd.CommandID),
+				zap.Int("messages", len(entry.Messages)))
+			if err := e.publish(ctx, entry.Messages); err != nil {
+				return err
+			}
+			delete(e.pending, cmd.CommandID)
+			return nil
+		}
+
+		e.logger.Warn("Skipping duplicate command",
+			zap.String("commandId", cmd.CommandID),
+			zap.Int("partition", pos.Partition),
+			zap.Int64("offset", pos.Offset))
+		return nil
+	}
+
+	e.logger.Info("Processing command",
+		zap.String("type", cmd.Type),
+		zap.String("orderId", cmd.OrderID),
+		zap.String("symbol", cmd.Symbol))
+
+	expired, results, err := e.processor.Run(cmd, adm.Admission)
+	if len(adm.Expiring) > 0 && !e.replaying {
+		e.logger.Warn("Heartbeats expired, orders cancelled",
+			zap.Strings("userIds", adm.Expiring),
+			zap.Int("books", len(expired)))
+	}
+	if err != nil {
+		if len(expired) > 0 {
+			e.admit(pos, &admission{expired: expired})
+		}
+		return err
+	}
+	if e.replaying {
+		return nil
+	}
+	e.logOutcome(cmd, results)
+	results = append(expired, results...)
+
+	var msgs []kafka.OutboundMessage
+	for _, result := range results {
+		encoded, err := wire.EncodeResult(e.matcher.Scale(result.Symbol), result)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, encoded...)
+	}
+	if len(msgs) > 0 {
+		if err := e.emit(ctx, pos, cmd.CommandID, msgs); err != nil {
+			return err
+		}
+	}
+
+	if cmd.Type == "SNAPSHOT_REQUEST" {
+		// Retried like the command's other messages: failing here would
+		// dead-letter a command that has already run.
+		return e.snapshots.publish(ctx, cmd.Symbol, true, e.publish)
+	}
+	return nil
+}
+
+// logOutcome logs what a cancel, amend or mass cancel did.
+func (e *engine) logOutcome(cmd *kafka.OrderCommand, results []*matcher.MatchResult) {
+	switch cmd.Type {
+	case "CANCEL":
+		if len(results) > 0 {
+			e.logger.Info("Order cancelled", zap.String("orderId", cmd.OrderID))
+		}
+
+	case "REPLACE", "AMEND":
+		if len(results) == 0 {
+			e.logger.Warn("Order to amend not found", zap.String("orderId", cmd.OrderID))
+		}
+
+	case "CANCEL_ALL":
+		cancelled := 0
+		for _, result := range results {
+			cancelled += len(result.OrderUpdates)
+		}
+		e.logger.Info("Orders cancelled",
+			zap.String("userId", cmd.UserID),
+			zap.String("symbol", cmd.Symbol),
+			zap.Int("count", cancelled))
+	}
+}
+
+// deadLetter publishes a message that could not be processed to the
+// dead-letter topic. Orders cancelled by heartbeats the command expired go
+// out first.
+func (e *engine) deadLetter(ctx context.Context, dl *kafka.DeadLetter) error {
+	expired := e.admission(dl.Position).expired
+	if e.replaying {
+		return nil
+	}
+
+	var msgs []kafka.OutboundMessage
+	for _, result := range expired {
+		encoded, err := wire.EncodeResult(e.matcher.Scale(result.Symbol), result)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, encoded...)
+	}
+
+	// The command's own timestamp if it could be read, else Kafka's.
+	var commandID, symbol string
+	now := dl.Time
+	if cmd := dl.Command; cmd != nil {
+		commandID, symbol = cmd.CommandID, cmd.Symbol
+		if cmd.Timestamp != 0 {
+			now = time.UnixMilli(cmd.Timestamp)
+		}
+	}
+	encoded, err := wire.EncodeDeadLetter(e.matcher.Scale(symbol), dl, now)
+	if err != nil {
+		return err
+	}
+	msgs = append(msgs, encoded...)
+
+	return e.emit(ctx, dl.Position, commandID, msgs)
+}
+
+// emit journals msgs and then publishes them.
+func (e *engine) emit(ctx context.Context, pos kafka.Position, commandID string, msgs []kafka.OutboundMessage) error {
+	if err := e.journal.Append(&journal.Entry{
+		Partition: pos.Partition,
+		Offset:    pos.Offset,
+		CommandID: commandID,
+		Messages:  msgs,
+	}); err != nil {
+		return fmt.Errorf("%w: journal offset %d/%d: %v", kafka.ErrStop, pos.Partition, pos.Offset, err)
+	}
+
+	return e.publish(ctx, msgs)
+}
+
+// publish retries until msgs are written or ctx is cancelled. Giving up on a
+// journaled command would leave its offset uncommitted behind later ones.
+// Messages written before a failure are not written again.
+func (e *engine) publish(ctx context.Context, msgs []kafka.OutboundMessage) error {
+	for {
+		err := e.producer.PublishMessages(ctx, msgs)
+		if err == nil {
+			return nil
+		}
+		var partial *kafka.PartialWriteError
+		if errors.As(err, &partial) {
+			msgs = partial.Unsent
+		}
+		e.logger.Error("Failed to publish messages", zap.Error(err), zap.Int("unsent", len(msgs)))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(publishRetryDelay):
+		}
+	}
+}
+
+// resolvePending marks journaled commands from the committed offsets up to
+// where replay stopped for re-publishing when the consumer group delivers
+// them again. Commands past that point were not replayed and run as new.
+func (e *engine) resolvePending(committed, replayed map[int]int64) {
+	e.pending = make(map[string]*journal.Entry)
+	for _, entry := range e.journal.Entries() {
+		if entry.CommandID == "" || entry.Offset < committed[entry.Partition] || entry.Offset >= replayed[entry.Partition] {
+			continue
+		}
+		e.pending[entry.CommandID] = entry
+	}
+}
+
+// compactOffsets returns offsets lowered to keep pending entries journaled
+// until they have been published again.
+func (e *engine) compactOffsets(offsets map[int]int64) map[int]int64 {
+	compact := make(map[int]int64, len(offsets))
+	for partition, offset := range offsets {
+		compact[partition] = offset
+	}
+	for _, entry := range e.pending {
+		if offset, ok := compact[entry.Partition]; ok && entry.Offset < offset {
+			compact[entry.Partition] = entry.Offset
+		}
+	}
+	return compact
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/opencode-exchange/matching-engine/internal/kafka"
+	kafkago "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// fakeWriter fails its first fails calls and keeps what the others write.
+type fakeWriter struct {
+	fails   int
+	calls   int
+	written []string
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafkago.Message) error {
+	w.calls++
+	if w.calls <= w.fails {
+		return errors.New("broker down")
+	}
+	for _, msg := range msgs {
+		w.written = append(w.written, string(msg.Value))
+	}
+	return nil
+}
+
+func (w *fakeWriter) Close() error { return nil }
+
+func TestPublishRetriesOnlyUnsentTopics(t *testing.T) {
+	trades, updates := &fakeWriter{}, &fakeWriter{fails: 2}
+	e := &engine{
+		producer: kafka.NewProducerWithWriters(map[string]kafka.Writer{
+			kafka.TopicTrades:       trades,
+			kafka.TopicOrderUpdates: updates,
+		}, zap.NewNop()),
+		logger: zap.NewNop(),
+	}
+
+	msgs := []kafka.OutboundMessage{
+		{Topic: kafka.TopicTrades, Key: "BTC/USDT", Value: []byte("t1")},
+		{Topic: kafka.TopicTrades, Key: "BTC/USDT", Value: []byte("t2")},
+		{Topic: kafka.TopicOrderUpdates, Key: "BTC/USDT", Value: []byte("o1")},
+	}
+	if err := e.publish(context.Background(), msgs); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fmt.Sprint(trades.written); got != "[t1 t2]" || trades.calls != 1 {
+		t.Fatalf("trades written %s in %d calls, want [t1 t2] once", got, trades.calls)
+	}
+	if got := fmt.Sprint(updates.written); got != "[o1]" || updates.calls != 3 {
+		t.Fatalf("order updates written %s in %d calls, want [o1] on the third", got, updates.calls)
+	}
+}
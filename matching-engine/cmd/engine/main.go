@@ -2,16 +2,22 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/opencode-exchange/matching-engine/internal/command"
+	"github.com/opencode-exchange/matching-engine/internal/fees"
+	"github.com/opencode-exchange/matching-engine/internal/journal"
 	"github.com/opencode-exchange/matching-engine/internal/kafka"
-	"github.com/opencode-exchange/matching-engine/internal/matcher"
-	"github.com/opencode-exchange/matching-engine/internal/orderbook"
-	"github.com/shopspring/decimal"
+	"github.com/opencode-exchange/matching-engine/internal/market"
+	"github.com/opencode-exchange/matching-engine/internal/snapshot"
 	"go.uber.org/zap"
 )
 
@@ -21,123 +27,78 @@ func main() {
 
 	brokers := strings.Split(getEnv("KAFKA_BROKERS", "localhost:9092"), ",")
 
-	m := matcher.NewMatcher()
+	snapshotInterval, err := time.ParseDuration(getEnv("SNAPSHOT_INTERVAL", "30s"))
+	if err != nil {
+		logger.Fatal("Invalid SNAPSHOT_INTERVAL", zap.Error(err))
+	}
+
+	bookSnapshotInterval, err := time.ParseDuration(getEnv("BOOK_SNAPSHOT_INTERVAL", "10s"))
+	if err != nil {
+		logger.Fatal("Invalid BOOK_SNAPSHOT_INTERVAL", zap.Error(err))
+	}
+
+	store, err := snapshot.NewStore(getEnv("SNAPSHOT_DIR", "data/snapshots"), 3, logger)
+	if err != nil {
+		logger.Fatal("Failed to open snapshot store", zap.Error(err))
+	}
+
+	markets, err := market.Load(getEnv("MARKETS_CONFIG", "config/markets.json"))
+	if err != nil {
+		logger.Fatal("Failed to load markets", zap.Error(err))
+	}
+
+	dedupWindow, err := strconv.Atoi(getEnv("DEDUP_WINDOW", "100000"))
+	if err != nil {
+		logger.Fatal("Invalid DEDUP_WINDOW", zap.Error(err))
+	}
+
+	processor, err := command.New(markets, dedupWindow)
+	if err != nil {
+		logger.Fatal("Invalid market", zap.Error(err))
+	}
+	m := processor.Matcher
+	if path := getEnv("FEE_TIERS_CONFIG", ""); path != "" {
+		tiers, err := fees.LoadTiers(path)
+		if err != nil {
+			logger.Fatal("Failed to load fee tiers", zap.Error(err))
+		}
+		if err := processor.Fees.ApplyTiers(tiers); err != nil {
+			logger.Fatal("Invalid fee tiers", zap.Error(err))
+		}
+	}
 	producer := kafka.NewProducer(brokers, logger)
 	defer producer.Close()
 
-	handler := func(cmd *kafka.OrderCommand) error {
-		logger.Info("Processing command",
-			zap.String("type", cmd.Type),
-			zap.String("orderId", cmd.OrderID),
-			zap.String("symbol", cmd.Symbol))
-
-		ctx := context.Background()
-
-		switch cmd.Type {
-		case "NEW":
-			payloadBytes, _ := json.Marshal(cmd.Payload)
-			var payload kafka.NewOrderPayload
-			if err := json.Unmarshal(payloadBytes, &payload); err != nil {
-				logger.Error("Failed to parse payload", zap.Error(err))
-				return err
-			}
-
-			side := orderbook.Buy
-			if payload.Side == "SELL" {
-				side = orderbook.Sell
-			}
-
-			orderType := orderbook.Limit
-			if payload.OrderType == "MARKET" {
-				orderType = orderbook.Market
-			}
-
-			price := decimal.Zero
-			if payload.Price != nil {
-				price, _ = decimal.NewFromString(*payload.Price)
-			}
-
-			quantity, _ := decimal.NewFromString(payload.Quantity)
-
-			order := orderbook.NewOrder(
-				cmd.OrderID,
-				cmd.UserID,
-				cmd.Symbol,
-				side,
-				orderType,
-				price,
-				quantity,
-			)
-
-			result := m.ProcessOrder(order)
-
-			trades := make([]*kafka.TradeEvent, len(result.Trades))
-			for i, t := range result.Trades {
-				trades[i] = &kafka.TradeEvent{
-					TradeID:      t.ID,
-					Symbol:       t.Symbol,
-					Price:        t.Price.String(),
-					Quantity:     t.Quantity.String(),
-					QuoteQty:     t.QuoteQty.String(),
-					MakerOrderID: t.MakerOrderID,
-					TakerOrderID: t.TakerOrderID,
-					MakerUserID:  t.MakerUserID,
-					TakerUserID:  t.TakerUserID,
-					IsBuyerMaker: t.IsBuyerMaker,
-					MakerFee:     "0",
-					TakerFee:     "0",
-					ExecutedAt:   t.ExecutedAt.UnixMilli(),
-				}
-			}
-
-			if len(trades) > 0 {
-				if err := producer.PublishTrades(ctx, trades); err != nil {
-					logger.Error("Failed to publish trades", zap.Error(err))
-					return err
-				}
-				logger.Info("Published trades", zap.Int("count", len(trades)))
-			}
-
-			if result.OrderbookDelta != nil && (len(result.OrderbookDelta.Bids) > 0 || len(result.OrderbookDelta.Asks) > 0) {
-				update := &kafka.OrderbookUpdateEvent{
-					Symbol:    result.OrderbookDelta.Symbol,
-					Sequence:  result.OrderbookDelta.Sequence,
-					Bids:      result.OrderbookDelta.Bids,
-					Asks:      result.OrderbookDelta.Asks,
-					Timestamp: result.OrderbookDelta.Timestamp,
-				}
-				if err := producer.PublishOrderbookUpdate(ctx, update); err != nil {
-					logger.Error("Failed to publish orderbook update", zap.Error(err))
-					return err
-				}
-			}
-
-		case "CANCEL":
-			cancelledOrder, delta := m.CancelOrder(cmd.Symbol, cmd.OrderID)
-			if cancelledOrder != nil {
-				logger.Info("Order cancelled", zap.String("orderId", cmd.OrderID))
-
-				if delta != nil {
-					update := &kafka.OrderbookUpdateEvent{
-						Symbol:    delta.Symbol,
-						Sequence:  delta.Sequence,
-						Bids:      delta.Bids,
-						Asks:      delta.Asks,
-						Timestamp: delta.Timestamp,
-					}
-					if err := producer.PublishOrderbookUpdate(ctx, update); err != nil {
-						logger.Error("Failed to publish orderbook update", zap.Error(err))
-						return err
-					}
-				}
-			}
-		}
+	ensureCtx, cancelEnsure := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := kafka.EnsureCompacted(ensureCtx, brokers, kafka.TopicOrderbookSnapshots); err != nil {
+		logger.Warn("Could not create compacted snapshots topic", zap.Error(err))
+	}
+	cancelEnsure()
 
-		return nil
+	jrnl, err := journal.Open(getEnv("JOURNAL_PATH", "data/journal.log"))
+	if err != nil {
+		logger.Fatal("Failed to open journal", zap.Error(err))
+	}
+	defer jrnl.Close()
+
+	e := &engine{
+		processor: processor,
+		matcher:   m,
+		producer:  producer,
+		journal:   jrnl,
+		logger:    logger,
+		snapshots: newBookSnapshots(m, processor.Clock, producer, logger),
+	}
+
+	workers, err := strconv.Atoi(getEnv("ENGINE_WORKERS", strconv.Itoa(runtime.NumCPU())))
+	if err != nil {
+		logger.Fatal("Invalid ENGINE_WORKERS", zap.Error(err))
 	}
 
-	consumer := kafka.NewConsumer(brokers, "orders", "matching-engine", handler, logger)
+	consumer := kafka.NewConsumer(brokers, "orders", "matching-engine", e.handle, logger)
 	defer consumer.Close()
+	consumer.SetDeadLetter(e.deadLetter)
+	consumer.SetWorkers(workers, e.schedule)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -150,10 +111,88 @@ func main() {
 		cancel()
 	}()
 
+	saveSnapshot := func(offsets map[int]int64) error {
+		if err := store.Save(&snapshot.Snapshot{
+			Offsets:    offsets,
+			Books:      m.Snapshot(),
+			CommandIDs: processor.Seen.IDs(),
+			Clock:      processor.Heartbeats.Now(),
+			Heartbeats: processor.Heartbeats.Deadlines(),
+			TradeIDs:   processor.TradeIDs.Last(),
+			CreatedAt:  time.Now().UnixMilli(),
+		}); err != nil {
+			return err
+		}
+		// Everything before the snapshot's offsets is either committed or will
+		// be replayed from the snapshot, so its journal entries are not needed.
+		return jrnl.Compact(e.compactOffsets(offsets))
+	}
+
+	snap, err := store.Latest()
+	if err != nil {
+		logger.Fatal("Failed to load snapshot", zap.Error(err))
+	}
+	var from map[int]int64
+	if snap != nil {
+		if err := m.Restore(snap.Books); err != nil {
+			logger.Fatal("Failed to restore snapshot", zap.Error(err))
+		}
+		processor.Seen.Restore(snap.CommandIDs)
+		processor.Heartbeats.Restore(snap.Clock, snap.Heartbeats)
+		processor.TradeIDs.Restore(snap.TradeIDs)
+		from = snap.Offsets
+		logger.Info("Restored snapshot",
+			zap.Uint64("id", snap.ID),
+			zap.Int("books", len(snap.Books)),
+			zap.Any("offsets", snap.Offsets))
+	}
+
+	// Without a snapshot every partition is rebuilt from offset 0, and startup
+	// fails if retention has dropped any of it. Replay also covers journaled commands past the committed
+	// offsets so that their state is rebuilt before the group delivers them
+	// again.
+	e.replaying = true
+	committed, err := consumer.Replay(ctx, from, jrnl.End())
+	if err != nil {
+		logger.Fatal("Failed to replay orders", zap.Error(err))
+	}
+	e.replaying = false
+
+	e.resolvePending(committed, consumer.Offsets())
+	if len(e.pending) > 0 {
+		logger.Info("Journaled commands awaiting re-publish", zap.Int("count", len(e.pending)))
+	}
+
+	consumer.SetCheckpoint(snapshotInterval, saveSnapshot)
+
+	if bookSnapshotInterval > 0 {
+		go e.snapshots.run(ctx, bookSnapshotInterval)
+	}
+
+	query := &http.Server{
+		Addr:              getEnv("QUERY_ADDR", ":8081"),
+		Handler:           newQueryServer(m, logger),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := query.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Query server stopped", zap.Error(err))
+		}
+	}()
+	defer func() {
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelShutdown()
+		query.Shutdown(shutdownCtx)
+	}()
+
 	logger.Info("Matching engine started")
 	if err := consumer.Start(ctx); err != nil && err != context.Canceled {
 		logger.Fatal("Consumer error", zap.Error(err))
 	}
+
+	if err := saveSnapshot(consumer.Offsets()); err != nil {
+		logger.Error("Failed to write final snapshot", zap.Error(err))
+	}
 }
 
 func getEnv(key, defaultValue string) string {
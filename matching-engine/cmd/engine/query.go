@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+	"github.com/opencode-exchange/matching-engine/internal/wire"
+	"go.uber.org/zap"
+)
+
+const defaultQueryLevels = 20
+
+// queryServer answers read-only HTTP queries about the books from their
+// published views, so it never waits on matching. Every answer comes from a
+// single view and carries its sequence, which orders it against the deltas
+// on the orderbook topic. Prices and quantities are decimal strings.
+//
+//	GET /depth?symbol=BTC/USDT&levels=20
+//	GET /l3?symbol=BTC/USDT&levels=20
+//	GET /order?symbol=BTC/USDT&id=...
+//	GET /stats[?symbol=BTC/USDT]
+type queryServer struct {
+	matcher *matcher.Matcher
+	logger  *zap.Logger
+}
+
+func newQueryServer(m *matcher.Matcher, logger *zap.Logger) http.Handler {
+	s := &queryServer{matcher: m, logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/depth", s.get(s.depth))
+	mux.HandleFunc("/l3", s.get(s.l3))
+	mux.HandleFunc("/order", s.get(s.order))
+	mux.HandleFunc("/stats", s.get(s.stats))
+	return mux
+}
+
+type depthResponse struct {
+	Symbol   string      `json:"symbol"`
+	Sequence uint64      `json:"sequence"`
+	Bids     [][2]string `json:"bids"`
+	Asks     [][2]string `json:"asks"`
+}
+
+type l3Response struct {
+	Symbol   string    `json:"symbol"`
+	Sequence uint64    `json:"sequence"`
+	Bids     []l3Level `json:"bids"`
+	Asks     []l3Level `json:"asks"`
+}
+
+type l3Level struct {
+	Price  string    `json:"price"`
+	Volume string    `json:"volume"`
+	Orders []l3Order `json:"orders"`
+}
+
+// l3Order shows only what the book shows: an iceberg's visible slice.
+type l3Order struct {
+	OrderID   string `json:"orderId"`
+	Quantity  string `json:"quantity"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// orderResponse describes a resting order, or a conditional order waiting
+// for its stop price, which is not Resting.
+type orderResponse struct {
+	OrderID      string  `json:"orderId"`
+	UserID       string  `json:"userId"`
+	Symbol       string  `json:"symbol"`
+	Side         string  `json:"side"`
+	OrderType    string  `json:"orderType"`
+	TimeInForce  string  `json:"timeInForce"`
+	Price        string  `json:"price"`
+	StopPrice    *string `json:"stopPrice,omitempty"`
+	Resting      bool    `json:"resting"`
+	Quantity     string  `json:"quantity"`
+	FilledQty    string  `json:"filledQty"`
+	RemainingQty string  `json:"remainingQty"`
+	VisibleQty   string  `json:"visibleQty"`
+	AvgPrice     string  `json:"avgPrice"`
+	Timestamp    int64   `json:"timestamp"`
+	Sequence     uint64  `json:"sequence"`
+}
+
+type statsResponse struct {
+	Symbol         string  `json:"symbol"`
+	Sequence       uint64  `json:"sequence"`
+	LastTradePrice *string `json:"lastTradePrice"`
+	BestBid        *string `json:"bestBid"`
+	BestAsk        *string `json:"bestAsk"`
+	BidLevels      int     `json:"bidLevels"`
+	AskLevels      int     `json:"askLevels"`
+	Orders         int     `json:"orders"`
+	Triggers       int     `json:"triggers"`
+}
+
+func (s *queryServer) get(handle func(r *http.Request) (int, interface{})) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, body := http.StatusMethodNotAllowed, interface{}(errorBody("method not allowed"))
+		if r.Method == http.MethodGet {
+			status, body = handle(r)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			s.logger.Debug("Failed to write query response", zap.Error(err))
+		}
+	}
+}
+
+func errorBody(msg string) map[string]string {
+	return map[string]string{"error": msg}
+}
+
+// view returns the view of the symbol in the query, or the status and body
+// to answer with if there is none.
+func (s *queryServer) view(r *http.Request) (*orderbook.View, int, interface{}) {
+	symbol := r.URL.Query().Get("symbol")
+	if symbol == "" {
+		return nil, http.StatusBadRequest, errorBody("symbol is required")
+	}
+	view := s.matcher.View(symbol)
+	if view == nil {
+		return nil, http.StatusNotFound, errorBody("unknown symbol")
+	}
+	return view, 0, nil
+}
+
+func queryLevels(r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("levels")
+	if value == "" {
+		return defaultQueryLevels, true
+	}
+	levels, err := strconv.Atoi(value)
+	return levels, err == nil && levels > 0
+}
+
+func (s *queryServer) depth(r *http.Request) (int, interface{}) {
+	view, status, body := s.view(r)
+	if view == nil {
+		return status, body
+	}
+	levels, ok := queryLevels(r)
+	if !ok {
+		return http.StatusBadRequest, errorBody("levels must be a positive integer")
+	}
+
+	bids, asks := view.Depth(levels)
+	return http.StatusOK, &depthResponse{
+		Symbol:   view.Symbol,
+		Sequence: view.Sequence,
+		Bids:     wire.FormatLevels(view.Scale, bids),
+		Asks:     wire.FormatLevels(view.Scale, asks),
+	}
+}
+
+func (s *queryServer) l3(r *http.Request) (int, interface{}) {
+	view, status, body := s.view(r)
+	if view == nil {
+		return status, body
+	}
+	levels, ok := queryLevels(r)
+	if !ok {
+		return http.StatusBadRequest, errorBody("levels must be a positive integer")
+	}
+
+	return http.StatusOK, &l3Response{
+		Symbol:   view.Symbol,
+		Sequence: view.Sequence,
+		Bids:     formatL3(view.Scale, view.Bids, levels),
+		Asks:     formatL3(view.Scale, view.Asks, levels),
+	}
+}
+
+func formatL3(scale orderbook.Scale, side orderbook.LevelViews, limit int) []l3Level {
+	levels := make([]l3Level, 0, min(limit, side.Len()))
+	side.Walk(func(level *orderbook.LevelView) bool {
+		if len(levels) >= limit {
+			return false
+		}
+		orders := make([]l3Order, len(level.Orders))
+		for i := range level.Orders {
+			o := &level.Orders[i]
+			orders[i] = l3Order{
+				OrderID:   o.ID,
+				Quantity:  scale.Qty(o.Visible()).String(),
+				Timestamp: o.Timestamp.UnixMilli(),
+			}
+		}
+		levels = append(levels, l3Level{
+			Price:  scale.Price(level.Price).String(),
+			Volume: scale.Qty(level.Volume).String(),
+			Orders: orders,
+		})
+		return true
+	})
+	return levels
+}
+
+func (s *queryServer) order(r *http.Request) (int, interface{}) {
+	view, status, body := s.view(r)
+	if view == nil {
+		return status, body
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return http.StatusBadRequest, errorBody("id is required")
+	}
+
+	o, resting := view.Order(id)
+	if !resting {
+		var waiting bool
+		if o, waiting = view.Trigger(id); !waiting {
+			return http.StatusNotFound, errorBody("order is not resting in the book or waiting for its stop price")
+		}
+	}
+	scale := view.Scale
+	resp := &orderResponse{
+		OrderID:      o.ID,
+		UserID:       o.UserID,
+		Symbol:       o.Symbol,
+		Side:         o.Side.String(),
+		OrderType:    o.Type.String(),
+		TimeInForce:  o.TimeInForce.String(),
+		Price:        scale.Price(o.Price).String(),
+		Resting:      resting,
+		Quantity:     scale.Qty(o.Quantity).String(),
+		FilledQty:    scale.Qty(o.FilledQty).String(),
+		RemainingQty: scale.Qty(o.RemainingQty).String(),
+		VisibleQty:   scale.Qty(o.Visible()).String(),
+		AvgPrice:     scale.AvgPrice(o.FilledQuote, o.FilledQty).String(),
+		Timestamp:    o.Timestamp.UnixMilli(),
+		Sequence:     view.Sequence,
+	}
+	if o.StopPrice != 0 {
+		stop := scale.Price(o.StopPrice).String()
+		resp.StopPrice = &stop
+	}
+	return http.StatusOK, resp
+}
+
+func (s *queryServer) stats(r *http.Request) (int, interface{}) {
+	views := s.matcher.Views()
+	if r.URL.Query().Get("symbol") != "" {
+		view, status, body := s.view(r)
+		if view == nil {
+			return status, body
+		}
+		views = []*orderbook.View{view}
+	}
+
+	stats := make([]statsResponse, len(views))
+	for i, view := range views {
+		price := func(ticks int64) *string {
+			p := view.Scale.Price(ticks).String()
+			return &p
+		}
+		st := statsResponse{
+			Symbol:    view.Symbol,
+			Sequence:  view.Sequence,
+			BidLevels: view.Bids.Len(),
+			AskLevels: view.Asks.Len(),
+			Orders:    view.Orders,
+			Triggers:  view.Triggers,
+		}
+		if view.LastTradePrice > 0 {
+			st.LastTradePrice = price(view.LastTradePrice)
+		}
+		if best := view.Bids.Best(); best != nil {
+			st.BestBid = price(best.Price)
+		}
+		if best := view.Asks.Best(); best != nil {
+			st.BestAsk = price(best.Price)
+		}
+		stats[i] = st
+	}
+	return http.StatusOK, stats
+}
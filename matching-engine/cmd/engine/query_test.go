@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+	"go.uber.org/zap"
+)
+
+const querySymbol = "BTC/USDT"
+
+// newTestQueryServer serves a book with bids at 99 and 98, asks at 101 and
+// 102, a trade at 100 and a stop order waiting at 105. Prices have two
+// decimals and quantities three.
+func newTestQueryServer(t *testing.T) http.Handler {
+	t.Helper()
+
+	scale := orderbook.Scale{PriceDecimals: 2, QtyDecimals: 3}
+	m := matcher.NewMatcher(nil, nil)
+	m.AddMarket(matcher.MarketRules{Symbol: querySymbol, Scale: scale})
+	m.AddMarket(matcher.MarketRules{Symbol: "ETH/USDT", Scale: scale})
+
+	order := func(id string, side orderbook.Side, price, qty int64) *orderbook.Order {
+		return orderbook.NewOrder(id, "u1", querySymbol, side, orderbook.Limit, price, qty)
+	}
+	m.ProcessOrder(order("b1", orderbook.Buy, 9900, 1000))
+	m.ProcessOrder(order("b2", orderbook.Buy, 9900, 500))
+	m.ProcessOrder(order("b3", orderbook.Buy, 9800, 2000))
+	m.ProcessOrder(order("a1", orderbook.Sell, 10000, 250))
+	m.ProcessOrder(orderbook.NewOrder("t1", "u2", querySymbol, orderbook.Buy, orderbook.Limit, 10000, 250))
+	m.ProcessOrder(order("a2", orderbook.Sell, 10100, 1500))
+	m.ProcessOrder(order("a3", orderbook.Sell, 10200, 3000))
+
+	stop := order("s1", orderbook.Buy, 10600, 1000)
+	stop.Type, stop.StopPrice = orderbook.StopLimit, 10500
+	m.ProcessOrder(stop)
+
+	return newQueryServer(m, zap.NewNop())
+}
+
+func query(t *testing.T, h http.Handler, path string, params url.Values, body interface{}) int {
+	t.Helper()
+
+	target := path
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("%s: content type %q", target, ct)
+	}
+	if body != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), body); err != nil {
+			t.Fatalf("%s: %v in %s", target, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+func TestQueryDepth(t *testing.T) {
+	h := newTestQueryServer(t)
+
+	var depth depthResponse
+	if code := query(t, h, "/depth", url.Values{"symbol": {querySymbol}}, &depth); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if depth.Symbol != querySymbol || depth.Sequence == 0 {
+		t.Fatalf("got %+v", depth)
+	}
+	want := [][2]string{{"99", "1.5"}, {"98", "2"}}
+	if len(depth.Bids) != 2 || depth.Bids[0] != want[0] || depth.Bids[1] != want[1] {
+		t.Fatalf("bids %v, want %v", depth.Bids, want)
+	}
+	want = [][2]string{{"101", "1.5"}, {"102", "3"}}
+	if len(depth.Asks) != 2 || depth.Asks[0] != want[0] || depth.Asks[1] != want[1] {
+		t.Fatalf("asks %v, want %v", depth.Asks, want)
+	}
+
+	if code := query(t, h, "/depth", url.Values{"symbol": {querySymbol}, "levels": {"1"}}, &depth); code != http.StatusOK {
+		t.Fatalf("levels=1: status %d", code)
+	}
+	if len(depth.Bids) != 1 || len(depth.Asks) != 1 {
+		t.Fatalf("levels=1: got %d bids and %d asks", len(depth.Bids), len(depth.Asks))
+	}
+}
+
+func TestQueryL3(t *testing.T) {
+	h := newTestQueryServer(t)
+
+	var l3 l3Response
+	if code := query(t, h, "/l3", url.Values{"symbol": {querySymbol}, "levels": {"1"}}, &l3); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(l3.Bids) != 1 || len(l3.Asks) != 1 {
+		t.Fatalf("got %d bid and %d ask levels, want 1 each", len(l3.Bids), len(l3.Asks))
+	}
+	bid := l3.Bids[0]
+	if bid.Price != "99" || bid.Volume != "1.5" || len(bid.Orders) != 2 ||
+		bid.Orders[0].OrderID != "b1" || bid.Orders[0].Quantity != "1" ||
+		bid.Orders[1].OrderID != "b2" || bid.Orders[1].Quantity != "0.5" {
+		t.Fatalf("best bid level %+v", bid)
+	}
+}
+
+func TestQueryOrder(t *testing.T) {
+	h := newTestQueryServer(t)
+
+	var o orderResponse
+	if code := query(t, h, "/order", url.Values{"symbol": {querySymbol}, "id": {"b2"}}, &o); code != http.StatusOK {
+		t.Fatalf("resting order: status %d", code)
+	}
+	if o.OrderID != "b2" || !o.Resting || o.Side != "BUY" || o.OrderType != "LIMIT" ||
+		o.Price != "99" || o.RemainingQty != "0.5" || o.StopPrice != nil {
+		t.Fatalf("resting order %+v", o)
+	}
+
+	o = orderResponse{}
+	if code := query(t, h, "/order", url.Values{"symbol": {querySymbol}, "id": {"s1"}}, &o); code != http.StatusOK {
+		t.Fatalf("stop order: status %d", code)
+	}
+	if o.OrderID != "s1" || o.Resting || o.OrderType != "STOP_LIMIT" ||
+		o.Price != "106" || o.StopPrice == nil || *o.StopPrice != "105" {
+		t.Fatalf("stop order %+v", o)
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	h := newTestQueryServer(t)
+
+	var stats []statsResponse
+	if code := query(t, h, "/stats", nil, &stats); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(stats) != 2 || stats[0].Symbol != querySymbol || stats[1].Symbol != "ETH/USDT" {
+		t.Fatalf("got %+v", stats)
+	}
+	btc := stats[0]
+	if btc.LastTradePrice == nil || *btc.LastTradePrice != "100" ||
+		btc.BestBid == nil || *btc.BestBid != "99" || btc.BestAsk == nil || *btc.BestAsk != "101" ||
+		btc.BidLevels != 2 || btc.AskLevels != 2 || btc.Orders != 5 || btc.Triggers != 1 {
+		t.Fatalf("BTC/USDT stats %+v", btc)
+	}
+	if eth := stats[1]; eth.LastTradePrice != nil || eth.BestBid != nil || eth.BestAsk != nil || eth.Orders != 0 {
+		t.Fatalf("ETH/USDT stats %+v", eth)
+	}
+
+	if code := query(t, h, "/stats", url.Values{"symbol": {"ETH/USDT"}}, &stats); code != http.StatusOK || len(stats) != 1 {
+		t.Fatalf("one symbol: status %d, %d books", code, len(stats))
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	h := newTestQueryServer(t)
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		params url.Values
+		want   int
+	}{
+		{"depth without symbol", "/depth", nil, http.StatusBadRequest},
+		{"depth of unknown symbol", "/depth", url.Values{"symbol": {"DOGE/USDT"}}, http.StatusNotFound},
+		{"depth with text levels", "/depth", url.Values{"symbol": {querySymbol}, "levels": {"ten"}}, http.StatusBadRequest},
+		{"depth with zero levels", "/depth", url.Values{"symbol": {querySymbol}, "levels": {"0"}}, http.StatusBadRequest},
+		{"depth with negative levels", "/depth", url.Values{"symbol": {querySymbol}, "levels": {"-3"}}, http.StatusBadRequest},
+		{"l3 of unknown symbol", "/l3", url.Values{"symbol": {"DOGE/USDT"}}, http.StatusNotFound},
+		{"l3 with bad levels", "/l3", url.Values{"symbol": {querySymbol}, "levels": {"1.5"}}, http.StatusBadRequest},
+		{"order without id", "/order", url.Values{"symbol": {querySymbol}}, http.StatusBadRequest},
+		{"order of unknown symbol", "/order", url.Values{"symbol": {"DOGE/USDT"}, "id": {"b1"}}, http.StatusNotFound},
+		{"unknown order", "/order", url.Values{"symbol": {querySymbol}, "id": {"nope"}}, http.StatusNotFound},
+		{"filled order", "/order", url.Values{"symbol": {querySymbol}, "id": {"a1"}}, http.StatusNotFound},
+		{"order in another book", "/order", url.Values{"symbol": {"ETH/USDT"}, "id": {"b1"}}, http.StatusNotFound},
+		{"stats of unknown symbol", "/stats", url.Values{"symbol": {"DOGE/USDT"}}, http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var body map[string]string
+			if code := query(t, h, tc.path, tc.params, &body); code != tc.want || body["error"] == "" {
+				t.Fatalf("status %d, body %v; want %d with an error", code, body, tc.want)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/depth?symbol=BTC/USDT", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,72 @@
+// Command replay runs a file of order commands, one JSON object per line,
+// through the matcher and writes the trades, order updates, book deltas and
+// dead letters the engine would publish for them. Time comes from the
+// commands and trade IDs are numbered per symbol, so the same commands
+// always give the same output.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/opencode-exchange/matching-engine/internal/command"
+	"github.com/opencode-exchange/matching-engine/internal/market"
+)
+
+func main() {
+	marketsPath := flag.String("markets", "config/markets.json", "markets config")
+	inPath := flag.String("in", "", "commands to replay (default stdin)")
+	outPath := flag.String("out", "", "where to write the output (default stdout)")
+	flag.Parse()
+
+	if err := replayFiles(*marketsPath, *inPath, *outPath); err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+		os.Exit(1)
+	}
+}
+
+func replayFiles(marketsPath, inPath, outPath string) error {
+	p, err := newProcessor(marketsPath)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if inPath != "" {
+		f, err := os.Open(inPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	out := os.Stdout
+	if outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := bufio.NewWriter(out)
+	if err := newReplayer(p, w).run(in); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// newProcessor sets up the markets and fees in marketsPath as the engine
+// does.
+func newProcessor(marketsPath string) (*command.Processor, error) {
+	markets, err := market.Load(marketsPath)
+	if err != nil {
+		return nil, err
+	}
+	return command.New(markets, dedupWindow)
+}
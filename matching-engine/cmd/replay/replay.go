@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/opencode-exchange/matching-engine/internal/command"
+	"github.com/opencode-exchange/matching-engine/internal/kafka"
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+	"github.com/opencode-exchange/matching-engine/internal/wire"
+)
+
+const dedupWindow = 100000
+
+// replayer runs commands through the same processor as the engine and writes
+// every message the engine would publish, one JSON object per line.
+type replayer struct {
+	processor *command.Processor
+	out       *json.Encoder
+}
+
+func newReplayer(p *command.Processor, out io.Writer) *replayer {
+	return &replayer{processor: p, out: json.NewEncoder(out)}
+}
+
+// run replays the commands read from in, one per line. Blank lines are
+// skipped; a line that is not a command stops the replay.
+func (r *replayer) run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := int64(1); scanner.Scan(); line++ {
+		value := scanner.Bytes()
+		if len(value) == 0 {
+			continue
+		}
+
+		var cmd kafka.OrderCommand
+		if err := json.Unmarshal(value, &cmd); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := r.replay(&cmd, line, value); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// replay runs one command. Its line number stands in for the offset on
+// dead letters.
+func (r *replayer) replay(cmd *kafka.OrderCommand, line int64, value []byte) error {
+	key := []byte(cmd.Symbol)
+	adm := r.processor.Admit(key, cmd)
+	if adm.Duplicate {
+		return nil
+	}
+
+	expired, results, err := r.processor.Run(cmd, adm)
+	if err := r.write(expired...); err != nil {
+		return err
+	}
+	if err != nil {
+		msgs, err := wire.EncodeDeadLetter(r.processor.Matcher.Scale(cmd.Symbol), &kafka.DeadLetter{
+			Position: kafka.Position{Offset: line},
+			Key:      key,
+			Value:    value,
+			Command:  cmd,
+			Err:      err,
+		}, time.UnixMilli(cmd.Timestamp))
+		if err != nil {
+			return err
+		}
+		return r.encode(msgs)
+	}
+	return r.write(results...)
+}
+
+func (r *replayer) write(results ...*matcher.MatchResult) error {
+	for _, result := range results {
+		msgs, err := wire.EncodeResult(r.processor.Matcher.Scale(result.Symbol), result)
+		if err != nil {
+			return err
+		}
+		if err := r.encode(msgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *replayer) encode(msgs []kafka.OutboundMessage) error {
+	for i := range msgs {
+		if err := r.out.Encode(&msgs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
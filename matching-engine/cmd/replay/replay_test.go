@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// TestGolden replays every scenario in testdata and compares the output with
+// its .golden file. Run with -update after a deliberate change in behavior
+// and review the diff.
+func TestGolden(t *testing.T) {
+	scenarios, err := filepath.Glob("testdata/*.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenarios) == 0 {
+		t.Fatal("no scenarios in testdata")
+	}
+
+	for _, scenario := range scenarios {
+		name := strings.TrimSuffix(filepath.Base(scenario), ".jsonl")
+		t.Run(name, func(t *testing.T) {
+			got := replayScenario(t, scenario)
+
+			golden := strings.TrimSuffix(scenario, ".jsonl") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s:\n%s", golden, diffLines(string(want), string(got)))
+			}
+		})
+	}
+}
+
+func TestReplayIsDeterministic(t *testing.T) {
+	scenarios, err := filepath.Glob("testdata/*.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scenario := range scenarios {
+		if first, second := replayScenario(t, scenario), replayScenario(t, scenario); !bytes.Equal(first, second) {
+			t.Errorf("%s: two replays differ:\n%s", scenario, diffLines(string(first), string(second)))
+		}
+	}
+}
+
+func replayScenario(t *testing.T, path string) []byte {
+	t.Helper()
+
+	p, err := newProcessor("testdata/markets.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	var out bytes.Buffer
+	if err := newReplayer(p, &out).run(in); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// diffLines lists the lines that differ between want and got.
+func diffLines(want, got string) string {
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+
+	var b strings.Builder
+	for i := 0; i < max(len(wantLines), len(gotLines)); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			fmt.Fprintf(&b, "line %d:\n- %s\n+ %s\n", i+1, w, g)
+		}
+	}
+	return b.String()
+}
@@ -3,7 +3,6 @@ module github.com/opencode-exchange/matching-engine
 go 1.21
 
 require (
-	github.com/google/uuid v1.5.0
 	github.com/segmentio/kafka-go v0.4.47
 	github.com/shopspring/decimal v1.3.1
 	go.uber.org/zap v1.26.0
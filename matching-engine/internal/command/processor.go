@@ -0,0 +1,209 @@
+// Package command runs order commands against the matcher. The engine and
+// the replay tool both go through it, so a replay changes the books exactly
+// as the engine does.
+package command
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/opencode-exchange/matching-engine/internal/deadman"
+	"github.com/opencode-exchange/matching-engine/internal/dedup"
+	"github.com/opencode-exchange/matching-engine/internal/fees"
+	"github.com/opencode-exchange/matching-engine/internal/kafka"
+	"github.com/opencode-exchange/matching-engine/internal/market"
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+	"github.com/opencode-exchange/matching-engine/internal/wire"
+)
+
+// Processor runs a command in two steps. Admit must see every command in the
+// order it was read; it drops repeated command IDs and keeps the heartbeat
+// switch. Run then applies the command to the matcher. Commands that Admit
+// does not mark exclusive may run concurrently with others for different
+// books.
+type Processor struct {
+	Matcher  *matcher.Matcher
+	Fees     *fees.Schedule
+	TradeIDs *matcher.SequentialIDs
+
+	// Clock is the matcher's, set to each command's timestamp before it runs
+	// so that a replay or a standby stamps its output the same way.
+	Clock *matcher.CommandClock
+
+	// Seen holds the most recent command IDs.
+	Seen *dedup.Window
+
+	// Heartbeats is driven by command timestamps rather than the wall clock,
+	// so a replay cancels the same orders at the same command.
+	Heartbeats *deadman.Switch
+}
+
+// New sets up a matcher for markets and their fees, and a processor that
+// remembers the last dedupWindow command IDs. Time comes from the commands
+// and trades are numbered per symbol, so the same commands always give the
+// same output.
+func New(markets []market.Market, dedupWindow int) (*Processor, error) {
+	clock := matcher.NewCommandClock()
+	tradeIDs := matcher.NewSequentialIDs()
+	schedule := fees.NewSchedule()
+
+	m := matcher.NewMatcher(clock, tradeIDs)
+	for _, mkt := range markets {
+		rules, err := mkt.Rules()
+		if err != nil {
+			return nil, err
+		}
+		m.AddMarket(rules)
+		if err := schedule.SetMarket(mkt.Symbol, mkt.BaseAsset, mkt.QuoteAsset, mkt.Fees()); err != nil {
+			return nil, fmt.Errorf("%s fees: %w", mkt.Symbol, err)
+		}
+	}
+	m.SetFeeSchedule(schedule)
+
+	return &Processor{
+		Matcher:    m,
+		Fees:       schedule,
+		TradeIDs:   tradeIDs,
+		Clock:      clock,
+		Seen:       dedup.NewWindow(dedupWindow),
+		Heartbeats: deadman.NewSwitch(),
+	}, nil
+}
+
+// Admission is what Admit decided about a command.
+type Admission struct {
+	// Duplicate commands were admitted before and must not run again.
+	Duplicate bool
+
+	// Exclusive commands must run alone: after every command read before
+	// them has finished and before any later one starts.
+	Exclusive bool
+
+	// Expiring are the users whose heartbeats expired at the command. Run
+	// cancels their orders before applying the command.
+	Expiring []string
+}
+
+// Admit records cmd, read with the given message key. Commands for one book,
+// keyed by its symbol, may run alongside others. So may HEARTBEAT and TICK:
+// heartbeats are armed and disarmed here, in command order, which leaves
+// nothing for Run to do.
+func (p *Processor) Admit(key []byte, cmd *kafka.OrderCommand) Admission {
+	if cmd.CommandID != "" {
+		if p.Seen.Contains(cmd.CommandID) {
+			return Admission{Duplicate: true, Exclusive: true}
+		}
+		p.Seen.Add(cmd.CommandID)
+	}
+
+	// Switches expire before a heartbeat is armed, so one that arrives after
+	// its own deadline still cancels the user's orders.
+	expiring := p.Heartbeats.Advance(cmd.Timestamp)
+	if cmd.Type == "HEARTBEAT" {
+		// Run reports a malformed heartbeat.
+		if timeoutMs, err := wire.ParseHeartbeat(cmd); err == nil {
+			if timeoutMs == 0 {
+				p.Heartbeats.Disarm(cmd.UserID)
+			} else {
+				p.Heartbeats.Arm(cmd.UserID, timeoutMs)
+			}
+		}
+	}
+	if len(expiring) > 0 {
+		return Admission{Exclusive: true, Expiring: expiring}
+	}
+
+	switch cmd.Type {
+	case "NEW", "CANCEL", "REPLACE", "AMEND", "CANCEL_ALL", "SNAPSHOT_REQUEST":
+		return Admission{Exclusive: cmd.Symbol == "" || string(key) != cmd.Symbol}
+
+	case "HEARTBEAT", "TICK":
+		return Admission{}
+	}
+	return Admission{Exclusive: true}
+}
+
+// Run applies an admitted command. It returns the orders cancelled by
+// expired heartbeats and a result per book the command itself changed. The
+// expirations stand even if the command fails.
+func (p *Processor) Run(cmd *kafka.OrderCommand, adm Admission) (expired, results []*matcher.MatchResult, err error) {
+	// Expired heartbeats cancel orders in every book. HEARTBEAT and TICK
+	// touch no book and, running alongside others, must not move the time of
+	// the books those are running on.
+	symbol := cmd.Symbol
+	if len(adm.Expiring) > 0 {
+		symbol = ""
+	}
+	if adm.Exclusive || cmd.Type != "HEARTBEAT" && cmd.Type != "TICK" {
+		p.Clock.Set(symbol, time.UnixMilli(cmd.Timestamp))
+	}
+
+	for _, userID := range adm.Expiring {
+		filter := matcher.CancelFilter{UserID: userID}
+		expired = append(expired, p.Matcher.CancelAll(filter, matcher.ReasonHeartbeatExpired)...)
+	}
+
+	results, err = p.apply(cmd)
+	return expired, results, err
+}
+
+func (p *Processor) apply(cmd *kafka.OrderCommand) ([]*matcher.MatchResult, error) {
+	switch cmd.Type {
+	case "NEW":
+		order, err := wire.ParseOrder(p.Matcher.Scale(cmd.Symbol), cmd)
+		if err != nil {
+			// An order that was read but breaks a rule, such as the tick
+			// size, is rejected like one the matcher refuses. Only a payload
+			// that cannot be read is dead-lettered.
+			reason, ok := wire.RejectReason(err)
+			if !ok || reason == matcher.ReasonMalformedCommand || cmd.OrderID == "" || cmd.UserID == "" {
+				return nil, err
+			}
+			return single(p.Matcher.RejectOrder(cmd.OrderID, cmd.UserID, cmd.Symbol, reason)), nil
+		}
+		return single(p.Matcher.ProcessOrder(order)), nil
+
+	case "CANCEL":
+		return single(p.Matcher.CancelOrder(cmd.Symbol, cmd.OrderID)), nil
+
+	case "REPLACE", "AMEND":
+		price, quantity, err := wire.ParseAmend(p.Matcher.Scale(cmd.Symbol), cmd)
+		if err != nil {
+			return nil, err
+		}
+		return single(p.Matcher.AmendOrder(cmd.Symbol, cmd.OrderID, price, quantity)), nil
+
+	case "CANCEL_ALL":
+		filter, err := wire.ParseCancelAll(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return p.Matcher.CancelAll(filter, matcher.ReasonMassCancel), nil
+
+	case "HEARTBEAT":
+		// Admit has armed or disarmed the switch.
+		_, err := wire.ParseHeartbeat(cmd)
+		return nil, err
+
+	case "TICK":
+		// Only moves the clock, which Admit has already done.
+		return nil, nil
+
+	case "SNAPSHOT_REQUEST":
+		// The engine publishes the snapshot once the command is through; an
+		// empty symbol asks for every book.
+		if cmd.Symbol != "" && p.Matcher.View(cmd.Symbol) == nil {
+			return nil, wire.Reject(matcher.ReasonUnknownSymbol, fmt.Errorf("unknown symbol %q", cmd.Symbol))
+		}
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unknown command type %q", cmd.Type)
+}
+
+func single(result *matcher.MatchResult) []*matcher.MatchResult {
+	if result == nil {
+		return nil
+	}
+	return []*matcher.MatchResult{result}
+}
@@ -0,0 +1,121 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/opencode-exchange/matching-engine/internal/kafka"
+	"github.com/opencode-exchange/matching-engine/internal/market"
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+)
+
+func TestHeartbeatsRunAlongsideOthers(t *testing.T) {
+	p, err := New(nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	beat := &kafka.OrderCommand{CommandID: "c1", UserID: "u1", Type: "HEARTBEAT", Timestamp: 1000,
+		Payload: map[string]interface{}{"timeoutMs": 500}}
+	if adm := p.Admit([]byte("u1"), beat); adm.Exclusive || adm.Duplicate {
+		t.Fatalf("heartbeat admitted as %+v", adm)
+	}
+	if deadline := p.Heartbeats.Deadlines()["u1"]; deadline != 1500 {
+		t.Fatalf("deadline %d after Admit, want 1500", deadline)
+	}
+
+	tick := &kafka.OrderCommand{CommandID: "c2", Type: "TICK", Timestamp: 1200}
+	if adm := p.Admit(nil, tick); adm.Exclusive || len(adm.Expiring) > 0 {
+		t.Fatalf("tick before the deadline admitted as %+v", adm)
+	}
+
+	tick = &kafka.OrderCommand{CommandID: "c3", Type: "TICK", Timestamp: 1500}
+	adm := p.Admit(nil, tick)
+	if !adm.Exclusive || len(adm.Expiring) != 1 || adm.Expiring[0] != "u1" {
+		t.Fatalf("tick at the deadline admitted as %+v", adm)
+	}
+
+	bad := &kafka.OrderCommand{CommandID: "c4", UserID: "u2", Type: "HEARTBEAT", Timestamp: 1600,
+		Payload: map[string]interface{}{"timeoutMs": -1}}
+	adm = p.Admit([]byte("u2"), bad)
+	if _, armed := p.Heartbeats.Deadlines()["u2"]; armed {
+		t.Fatal("malformed heartbeat armed the switch")
+	}
+	if _, _, err := p.Run(bad, adm); err == nil {
+		t.Fatal("malformed heartbeat ran without error")
+	}
+}
+
+func TestHeartbeatThatExpiresAnother(t *testing.T) {
+	p, err := New(nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	beat := func(id, userID string, ts int64, timeoutMs int) *kafka.OrderCommand {
+		return &kafka.OrderCommand{CommandID: id, UserID: userID, Type: "HEARTBEAT", Timestamp: ts,
+			Payload: map[string]interface{}{"timeoutMs": timeoutMs}}
+	}
+	p.Admit([]byte("a"), beat("c1", "a", 1000, 500))
+	p.Admit([]byte("b"), beat("c2", "b", 1000, 5000))
+
+	adm := p.Admit([]byte("b"), beat("c3", "b", 1600, 5000))
+	if !adm.Exclusive || len(adm.Expiring) != 1 || adm.Expiring[0] != "a" {
+		t.Fatalf("b's heartbeat admitted as %+v, want a expiring", adm)
+	}
+	deadlines := p.Heartbeats.Deadlines()
+	if _, armed := deadlines["a"]; armed {
+		t.Fatal("a is still armed")
+	}
+	if deadlines["b"] != 6600 {
+		t.Fatalf("b's deadline %d, want 6600", deadlines["b"])
+	}
+
+	// A heartbeat after the user's own deadline cancels and then re-arms.
+	adm = p.Admit([]byte("b"), beat("c4", "b", 7000, 1000))
+	if len(adm.Expiring) != 1 || adm.Expiring[0] != "b" {
+		t.Fatalf("late heartbeat admitted as %+v, want b expiring", adm)
+	}
+	if deadline := p.Heartbeats.Deadlines()["b"]; deadline != 8000 {
+		t.Fatalf("b's deadline %d after the late heartbeat, want 8000", deadline)
+	}
+}
+
+func TestInvalidOrdersAreRejected(t *testing.T) {
+	p, err := New([]market.Market{{Symbol: "BTC/USDT", BaseAsset: "BTC", QuoteAsset: "USDT",
+		PriceDecimals: 2, QtyDecimals: 3}}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		payload map[string]interface{}
+		reason  string
+	}{
+		{"price finer than the tick", map[string]interface{}{"side": "BUY", "orderType": "LIMIT", "price": "100.001", "quantity": "1"}, matcher.ReasonTickSize},
+		{"quantity finer than the step", map[string]interface{}{"side": "BUY", "orderType": "LIMIT", "price": "100", "quantity": "0.0001"}, matcher.ReasonStepSize},
+		{"unknown side", map[string]interface{}{"side": "HOLD", "orderType": "LIMIT", "price": "100", "quantity": "1"}, matcher.ReasonInvalidOrder},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := &kafka.OrderCommand{CommandID: tc.name, OrderID: "o1", UserID: "u1", Symbol: "BTC/USDT",
+				Type: "NEW", Timestamp: 1000, Payload: tc.payload}
+			_, results, err := p.Run(cmd, p.Admit([]byte(cmd.Symbol), cmd))
+			if err != nil {
+				t.Fatalf("dead-lettered: %v", err)
+			}
+			if len(results) != 1 || len(results[0].OrderUpdates) != 1 {
+				t.Fatalf("got %d results, want one order update", len(results))
+			}
+			if update := results[0].OrderUpdates[0]; update.OrderID != "o1" || update.Status != matcher.StatusRejected ||
+				update.Reason != tc.reason || update.UpdatedAt.UnixMilli() != 1000 {
+				t.Fatalf("got %+v, want REJECTED with %s", update, tc.reason)
+			}
+		})
+	}
+
+	cmd := &kafka.OrderCommand{CommandID: "c-bad", OrderID: "o2", UserID: "u1", Symbol: "BTC/USDT",
+		Type: "NEW", Timestamp: 1000, Payload: map[string]interface{}{"side": "BUY", "quantity": 1}}
+	if _, _, err := p.Run(cmd, p.Admit([]byte(cmd.Symbol), cmd)); err == nil {
+		t.Fatal("unreadable payload was not dead-lettered")
+	}
+}
@@ -0,0 +1,89 @@
+package deadman
+
+import "sort"
+
+// Switch tracks a heartbeat deadline per user. Its clock only moves when
+// Advance is given a later timestamp, so replaying the same commands expires
+// the same users at the same point. Times are Unix milliseconds.
+type Switch struct {
+	now       int64
+	deadlines map[string]int64
+
+	// earliest is no later than every deadline, so most calls to Advance
+	// return without looking at them.
+	earliest int64
+}
+
+func NewSwitch() *Switch {
+	return &Switch{deadlines: make(map[string]int64)}
+}
+
+func (s *Switch) Now() int64 {
+	return s.now
+}
+
+// Arm sets the user's deadline to timeoutMs past the current time.
+func (s *Switch) Arm(userID string, timeoutMs int64) {
+	deadline := s.now + timeoutMs
+	s.deadlines[userID] = deadline
+	if len(s.deadlines) == 1 || deadline < s.earliest {
+		s.earliest = deadline
+	}
+}
+
+func (s *Switch) Disarm(userID string) {
+	delete(s.deadlines, userID)
+}
+
+// Advance moves the clock to ts if that is later and returns the users whose
+// deadlines have passed, earliest first. They are disarmed.
+func (s *Switch) Advance(ts int64) []string {
+	if ts > s.now {
+		s.now = ts
+	}
+	if len(s.deadlines) == 0 || s.now < s.earliest {
+		return nil
+	}
+
+	var expired []string
+	s.earliest = 0
+	for userID, deadline := range s.deadlines {
+		if deadline <= s.now {
+			expired = append(expired, userID)
+		} else if s.earliest == 0 || deadline < s.earliest {
+			s.earliest = deadline
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		a, b := s.deadlines[expired[i]], s.deadlines[expired[j]]
+		if a != b {
+			return a < b
+		}
+		return expired[i] < expired[j]
+	})
+	for _, userID := range expired {
+		delete(s.deadlines, userID)
+	}
+	return expired
+}
+
+// Deadlines returns every armed user's deadline.
+func (s *Switch) Deadlines() map[string]int64 {
+	deadlines := make(map[string]int64, len(s.deadlines))
+	for userID, deadline := range s.deadlines {
+		deadlines[userID] = deadline
+	}
+	return deadlines
+}
+
+func (s *Switch) Restore(now int64, deadlines map[string]int64) {
+	s.now = now
+	s.deadlines = make(map[string]int64, len(deadlines))
+	s.earliest = 0
+	for userID, deadline := range deadlines {
+		s.deadlines[userID] = deadline
+		if s.earliest == 0 || deadline < s.earliest {
+			s.earliest = deadline
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package dedup
+
+// Window remembers the most recent command IDs up to a fixed capacity,
+// forgetting the oldest first.
+type Window struct {
+	ids   map[string]struct{}
+	ring  []string
+	next  int
+	count int
+}
+
+func NewWindow(capacity int) *Window {
+	return &Window{
+		ids:  make(map[string]struct{}, capacity),
+		ring: make([]string, capacity),
+	}
+}
+
+func (w *Window) Contains(id string) bool {
+	_, ok := w.ids[id]
+	return ok
+}
+
+func (w *Window) Add(id string) {
+	if len(w.ring) == 0 || w.Contains(id) {
+		return
+	}
+
+	if w.count == len(w.ring) {
+		delete(w.ids, w.ring[w.next])
+	} else {
+		w.count++
+	}
+
+	w.ring[w.next] = id
+	w.ids[id] = struct{}{}
+	w.next = (w.next + 1) % len(w.ring)
+}
+
+// IDs returns the remembered IDs from oldest to newest.
+func (w *Window) IDs() []string {
+	ids := make([]string, 0, w.count)
+	start := (w.next - w.count + len(w.ring)) % max(len(w.ring), 1)
+	for i := 0; i < w.count; i++ {
+		ids = append(ids, w.ring[(start+i)%len(w.ring)])
+	}
+	return ids
+}
+
+func (w *Window) Restore(ids []string) {
+	w.ids = make(map[string]struct{}, len(w.ring))
+	w.next, w.count = 0, 0
+	for i := range w.ring {
+		w.ring[i] = ""
+	}
+	for _, id := range ids {
+		w.Add(id)
+	}
+}
@@ -0,0 +1,199 @@
+package fees
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// Decimals is the precision fees are charged at, matching the trades table.
+const Decimals = 8
+
+// Rates are fractions of the traded amount. A negative maker rate is a rebate
+// paid to the maker.
+type Rates struct {
+	Maker decimal.Decimal `json:"makerFee"`
+	Taker decimal.Decimal `json:"takerFee"`
+}
+
+// Validate rejects a negative taker fee. Whether a maker rebate is covered
+// depends on the takers it may trade with; Schedule checks that.
+func (r Rates) Validate() error {
+	if r.Taker.IsNegative() {
+		return fmt.Errorf("taker fee %s is negative", r.Taker)
+	}
+	return nil
+}
+
+// lowest returns the lowest maker rate and the lowest taker rate of rates,
+// which may belong to different ones.
+func lowest(rates []Rates) Rates {
+	low := rates[0]
+	for _, r := range rates[1:] {
+		low.Maker = decimal.Min(low.Maker, r.Maker)
+		low.Taker = decimal.Min(low.Taker, r.Taker)
+	}
+	return low
+}
+
+// Fee is an amount charged on one side of a trade. A negative amount is
+// credited.
+type Fee struct {
+	Amount decimal.Decimal
+	Asset  string
+}
+
+type market struct {
+	rates      Rates
+	baseAsset  string
+	quoteAsset string
+}
+
+// Schedule resolves the fee for each side of a trade. A user assigned to a
+// tier pays the tier's rates on every market instead of the market's.
+type Schedule struct {
+	markets   map[string]market
+	tiers     map[string]Rates
+	userTiers map[string]string
+}
+
+func NewSchedule() *Schedule {
+	return &Schedule{
+		markets:   make(map[string]market),
+		tiers:     make(map[string]Rates),
+		userTiers: make(map[string]string),
+	}
+}
+
+func (s *Schedule) SetMarket(symbol, baseAsset, quoteAsset string, rates Rates) error {
+	if err := rates.Validate(); err != nil {
+		return fmt.Errorf("market %s: %w", symbol, err)
+	}
+	old, existed := s.markets[symbol]
+	s.markets[symbol] = market{rates: rates, baseAsset: baseAsset, quoteAsset: quoteAsset}
+	if err := s.check(); err != nil {
+		if existed {
+			s.markets[symbol] = old
+		} else {
+			delete(s.markets, symbol)
+		}
+		return fmt.Errorf("market %s: %w", symbol, err)
+	}
+	return nil
+}
+
+func (s *Schedule) SetTier(name string, rates Rates) error {
+	if err := rates.Validate(); err != nil {
+		return fmt.Errorf("tier %s: %w", name, err)
+	}
+	old, existed := s.tiers[name]
+	s.tiers[name] = rates
+	if err := s.check(); err != nil {
+		if existed {
+			s.tiers[name] = old
+		} else {
+			delete(s.tiers, name)
+		}
+		return fmt.Errorf("tier %s: %w", name, err)
+	}
+	return nil
+}
+
+// check rejects a schedule under which a trade could cost the exchange
+// money. Maker and taker are different users who may each pay a tier's rates
+// or the market's, so on every market the lowest maker rate anyone can pay
+// must be covered by the lowest taker rate anyone can pay.
+func (s *Schedule) check() error {
+	tiers := make([]Rates, 0, len(s.tiers))
+	for _, rates := range s.tiers {
+		tiers = append(tiers, rates)
+	}
+
+	symbols := make([]string, 0, len(s.markets))
+	for symbol := range s.markets {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	for _, symbol := range symbols {
+		low := lowest(append([]Rates{s.markets[symbol].rates}, tiers...))
+		if low.Maker.Add(low.Taker).IsNegative() {
+			return fmt.Errorf("maker rebate %s on %s exceeds lowest taker fee %s", low.Maker.Neg(), symbol, low.Taker)
+		}
+	}
+	if len(tiers) > 0 {
+		if low := lowest(tiers); low.Maker.Add(low.Taker).IsNegative() {
+			return fmt.Errorf("maker rebate %s exceeds lowest taker fee %s", low.Maker.Neg(), low.Taker)
+		}
+	}
+	return nil
+}
+
+func (s *Schedule) SetUserTier(userID, tier string) error {
+	if _, ok := s.tiers[tier]; !ok {
+		return fmt.Errorf("user %s: unknown tier %q", userID, tier)
+	}
+	s.userTiers[userID] = tier
+	return nil
+}
+
+func (s *Schedule) Rates(symbol, userID string) Rates {
+	if tier, ok := s.userTiers[userID]; ok {
+		return s.tiers[tier]
+	}
+	return s.markets[symbol].rates
+}
+
+// Charge returns the fee for one side of a trade. Buyers pay in the base
+// asset they receive and sellers in the quote asset they receive. Amounts are
+// rounded up to Decimals, so charges round up and rebates round down.
+func (s *Schedule) Charge(symbol, userID string, isMaker, isBuyer bool, qty, quoteQty decimal.Decimal) Fee {
+	rates := s.Rates(symbol, userID)
+	rate := rates.Taker
+	if isMaker {
+		rate = rates.Maker
+	}
+
+	mkt := s.markets[symbol]
+	if isBuyer {
+		return Fee{Amount: qty.Mul(rate).RoundCeil(Decimals), Asset: mkt.baseAsset}
+	}
+	return Fee{Amount: quoteQty.Mul(rate).RoundCeil(Decimals), Asset: mkt.quoteAsset}
+}
+
+// Tiers is the content of a fee tier file: rates by tier name and the tier
+// each user is assigned to.
+type Tiers struct {
+	Tiers map[string]Rates  `json:"tiers"`
+	Users map[string]string `json:"users"`
+}
+
+func LoadTiers(path string) (*Tiers, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tiers Tiers
+	if err := json.Unmarshal(data, &tiers); err != nil {
+		return nil, err
+	}
+	return &tiers, nil
+}
+
+func (s *Schedule) ApplyTiers(tiers *Tiers) error {
+	for name, rates := range tiers.Tiers {
+		if err := s.SetTier(name, rates); err != nil {
+			return err
+		}
+	}
+	for userID, tier := range tiers.Users {
+		if err := s.SetUserTier(userID, tier); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package fees
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func rates(maker, taker string) Rates {
+	return Rates{Maker: decimal.RequireFromString(maker), Taker: decimal.RequireFromString(taker)}
+}
+
+func newTestSchedule(t *testing.T) *Schedule {
+	t.Helper()
+	s := NewSchedule()
+	if err := s.SetMarket("BTC/USDT", "BTC", "USDT", rates("0.001", "0.002")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetMarket("ETH/USDT", "ETH", "USDT", rates("0.0015", "0.0025")); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRebatesMustBeCoveredByEveryTaker(t *testing.T) {
+	s := newTestSchedule(t)
+
+	if err := s.SetTier("MM", rates("-0.0008", "0.001")); err != nil {
+		t.Fatalf("rebate below every taker fee: %v", err)
+	}
+	// VIP is fine on its own, but its takers would trade with MM makers for
+	// less than their rebate.
+	if err := rates("0.001", "0.0005").Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetTier("VIP", rates("0.001", "0.0005")); err == nil {
+		t.Fatal("VIP takers do not cover MM rebates")
+	}
+	if err := s.SetUserTier("u1", "VIP"); err == nil {
+		t.Fatal("rejected tier was kept")
+	}
+
+	if err := s.SetMarket("SOL/USDT", "SOL", "USDT", rates("-0.002", "0.0015")); err == nil {
+		t.Fatal("market rebate exceeds its own taker fee")
+	}
+	if err := s.SetTier("VIP", rates("0.001", "0.0008")); err != nil {
+		t.Fatalf("VIP takers cover MM rebates exactly: %v", err)
+	}
+	if err := rates("0.001", "-0.0001").Validate(); err == nil {
+		t.Fatal("negative taker fee")
+	}
+}
+
+func TestApplyTiersChecksAcrossTiers(t *testing.T) {
+	s := newTestSchedule(t)
+	err := s.ApplyTiers(&Tiers{Tiers: map[string]Rates{
+		"MM":  rates("-0.0008", "0.001"),
+		"VIP": rates("0.001", "0.0005"),
+	}})
+	if err == nil {
+		t.Fatal("tiers whose maker rebate exceeds another's taker fee were applied")
+	}
+}
+
+func TestTierSelection(t *testing.T) {
+	s := newTestSchedule(t)
+	if err := s.ApplyTiers(&Tiers{
+		Tiers: map[string]Rates{"VIP": rates("0.0005", "0.001")},
+		Users: map[string]string{"vip": "VIP"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUserTier("u1", "GOLD"); err == nil {
+		t.Fatal("unknown tier assigned")
+	}
+
+	for _, tc := range []struct {
+		symbol, userID string
+		want           Rates
+	}{
+		{"BTC/USDT", "retail", rates("0.001", "0.002")},
+		{"ETH/USDT", "retail", rates("0.0015", "0.0025")},
+		{"BTC/USDT", "vip", rates("0.0005", "0.001")},
+		{"ETH/USDT", "vip", rates("0.0005", "0.001")},
+	} {
+		if got := s.Rates(tc.symbol, tc.userID); !got.Maker.Equal(tc.want.Maker) || !got.Taker.Equal(tc.want.Taker) {
+			t.Errorf("%s on %s pays %s/%s, want %s/%s", tc.userID, tc.symbol, got.Maker, got.Taker, tc.want.Maker, tc.want.Taker)
+		}
+	}
+}
+
+func TestCharge(t *testing.T) {
+	s := newTestSchedule(t)
+	if err := s.ApplyTiers(&Tiers{
+		Tiers: map[string]Rates{"MM": rates("-0.0001", "0.001")},
+		Users: map[string]string{"mm": "MM"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	qty, quote := decimal.RequireFromString("0.123456789"), decimal.RequireFromString("1234.56789012")
+
+	for _, tc := range []struct {
+		name             string
+		userID           string
+		isMaker, isBuyer bool
+		amount, asset    string
+	}{
+		// Buyers pay in the base asset they receive, sellers in the quote.
+		{"taker buyer", "retail", false, true, "0.00024692", "BTC"},
+		{"taker seller", "retail", false, false, "2.46913579", "USDT"},
+		{"maker buyer", "retail", true, true, "0.00012346", "BTC"},
+		// Charges round up, rebates round towards zero.
+		{"rebated maker buyer", "mm", true, true, "-0.00001234", "BTC"},
+		{"rebated maker seller", "mm", true, false, "-0.12345678", "USDT"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fee := s.Charge("BTC/USDT", tc.userID, tc.isMaker, tc.isBuyer, qty, quote)
+			if fee.Amount.String() != tc.amount || fee.Asset != tc.asset {
+				t.Fatalf("got %s %s, want %s %s", fee.Amount, fee.Asset, tc.amount, tc.asset)
+			}
+		})
+	}
+}
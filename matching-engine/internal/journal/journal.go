@@ -0,0 +1,211 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencode-exchange/matching-engine/internal/kafka"
+)
+
+// Entry holds everything a command published, written before publishing so
+// that the exact same messages can be sent again if the engine dies before
+// the command's offset is committed.
+type Entry struct {
+	Partition int                     `json:"partition"`
+	Offset    int64                   `json:"offset"`
+	CommandID string                  `json:"commandId"`
+	Messages  []kafka.OutboundMessage `json:"messages"`
+}
+
+// Journal is safe for concurrent use.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries []*Entry
+
+	// written counts the entries appended and synced those known to be on
+	// disk. While syncing is set one Append is syncing the file for every
+	// entry written so far; the others wait on synced for it.
+	written uint64
+	synced  uint64
+	syncing bool
+	done    *sync.Cond
+}
+
+// Open loads the journal at path, creating it if needed. A torn final line
+// left by a crash mid-write is ignored.
+func Open(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{path: path}
+	j.done = sync.NewCond(&j.mu)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+		j.entries = append(j.entries, &entry)
+	}
+
+	// Rewrite so a torn line is not followed by new entries.
+	if err := j.rewrite(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Append writes entry and returns once it is on disk. Appends that arrive
+// while a sync is running share the next one, so concurrent callers do not
+// each wait for an fsync of their own.
+func (j *Journal) Append(entry *Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	j.entries = append(j.entries, entry)
+	j.written++
+
+	for seq := j.written; j.synced < seq; {
+		if j.syncing {
+			j.done.Wait()
+			continue
+		}
+
+		j.syncing = true
+		file, upTo := j.file, j.written
+		j.mu.Unlock()
+		err := file.Sync()
+		j.mu.Lock()
+		j.syncing = false
+		j.done.Broadcast()
+
+		if err != nil {
+			return err
+		}
+		j.synced = max(j.synced, upTo)
+	}
+	return nil
+}
+
+func (j *Journal) Entries() []*Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return append([]*Entry(nil), j.entries...)
+}
+
+// End returns, per partition, the offset after the last journaled command.
+func (j *Journal) End() map[int]int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	end := make(map[int]int64)
+	for _, entry := range j.entries {
+		if entry.Offset+1 > end[entry.Partition] {
+			end[entry.Partition] = entry.Offset + 1
+		}
+	}
+	return end
+}
+
+// Compact drops entries below offsets, which a snapshot has covered and whose
+// messages were therefore committed.
+func (j *Journal) Compact(offsets map[int]int64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.waitSync()
+	kept := make([]*Entry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		if to, ok := offsets[entry.Partition]; ok && entry.Offset < to {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	j.entries = kept
+
+	return j.rewrite()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.waitSync()
+	return j.file.Close()
+}
+
+// waitSync waits for a running sync to finish, so the file can be replaced
+// or closed.
+func (j *Journal) waitSync() {
+	for j.syncing {
+		j.done.Wait()
+	}
+}
+
+func (j *Journal) rewrite() error {
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, entry := range j.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if j.file != nil {
+		j.file.Close()
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	j.file = file
+	j.synced = j.written
+	return nil
+}
@@ -0,0 +1,54 @@
+package journal
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentAppendsSurviveReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.log")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, perWriter = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(partition int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				entry := &Entry{Partition: partition, Offset: int64(i), CommandID: fmt.Sprintf("c%d-%d", partition, i)}
+				if err := j.Append(entry); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if err := j.Compact(map[int]int64{0: perWriter}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if got, want := len(reopened.Entries()), (writers-1)*perWriter; got != want {
+		t.Fatalf("got %d entries after reopen, want %d", got, want)
+	}
+	end := reopened.End()
+	if _, ok := end[0]; ok || end[1] != perWriter {
+		t.Fatalf("end %v, want no partition 0 and partition 1 at %d", end, perWriter)
+	}
+}
@@ -3,6 +3,12 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
@@ -19,20 +25,109 @@ type OrderCommand struct {
 }
 
 type NewOrderPayload struct {
-	Side          string  `json:"side"`
-	OrderType     string  `json:"orderType"`
-	Price         *string `json:"price"`
-	Quantity      string  `json:"quantity"`
-	ClientOrderID *string `json:"clientOrderId"`
+	Side            string  `json:"side"`
+	OrderType       string  `json:"orderType"`
+	Price           *string `json:"price"`
+	StopPrice       *string `json:"stopPrice"`
+	Quantity        string  `json:"quantity"`
+	QuoteQuantity   *string `json:"quoteQuantity"`
+	DisplayQuantity *string `json:"displayQuantity"`
+	ClientOrderID   *string `json:"clientOrderId"`
+	TimeInForce     *string `json:"timeInForce"`
+	STP             *string `json:"selfTradePrevention"`
+	MaxSlippageBps  *int64  `json:"maxSlippageBps"`
 }
 
+// AmendOrderPayload changes a resting order's price, its total quantity, or
+// both. Omitted fields keep their current value.
+type AmendOrderPayload struct {
+	Price    *string `json:"price"`
+	Quantity *string `json:"quantity"`
+}
+
+// CancelAllPayload narrows a CANCEL_ALL, which otherwise cancels every order
+// of the command's user and/or symbol, to one side.
+type CancelAllPayload struct {
+	Side *string `json:"side"`
+}
+
+// HeartbeatPayload arms the user's dead man's switch: if no heartbeat
+// follows within TimeoutMs of command time, all of the user's orders are
+// cancelled. A TimeoutMs of zero disarms it.
+type HeartbeatPayload struct {
+	TimeoutMs int64 `json:"timeoutMs"`
+}
+
+// Position identifies the message a command was read from.
+type Position struct {
+	Partition int
+	Offset    int64
+}
+
+type Handler func(ctx context.Context, cmd *OrderCommand, pos Position) error
+
+// ErrStop, when wrapped in a handler error, stops the consumer without
+// committing the message so that it is delivered again after a restart.
+var ErrStop = errors.New("stop consuming")
+
+// ErrMalformed is wrapped by dead letters for messages that are not valid
+// commands.
+var ErrMalformed = errors.New("malformed command")
+
+// ErrHistoryLost is returned by Replay when retention has dropped messages
+// that no snapshot covers, so the books cannot be rebuilt.
+var ErrHistoryLost = errors.New("messages needed to rebuild the books are no longer retained")
+
+// DeadLetter is a message that could not be processed. Command holds whatever
+// could be read from it, or is nil if nothing could. Time is the message's
+// Kafka timestamp.
+type DeadLetter struct {
+	Position
+	Key     []byte
+	Value   []byte
+	Time    time.Time
+	Command *OrderCommand
+	Err     error
+}
+
+// DeadLetterFunc disposes of a message that could not be processed. An error
+// stops the consumer without committing the message.
+type DeadLetterFunc func(ctx context.Context, dl *DeadLetter) error
+
+type CheckpointFunc func(offsets map[int]int64) error
+
+// ScheduleFunc is called for every command in the order commands are read,
+// before its handler runs. It reports whether the command must run alone:
+// after every command read before it has finished and before any later one
+// starts. Other commands run on the worker their message key hashes to, so
+// commands with the same key are handled one at a time and in order.
+type ScheduleFunc func(key []byte, cmd *OrderCommand, pos Position) (exclusive bool)
+
+// workerQueue is how many commands may wait for each worker.
+const workerQueue = 256
+
 type Consumer struct {
 	reader  *kafka.Reader
-	handler func(cmd *OrderCommand) error
+	brokers []string
+	topic   string
+	groupID string
+	handler Handler
 	logger  *zap.Logger
+
+	deadLetter DeadLetterFunc
+
+	workers  int
+	schedule ScheduleFunc
+
+	offsets            map[int]int64
+	checkpoint         CheckpointFunc
+	checkpointInterval time.Duration
+
+	// progressed is set when an offset moves and cleared by a checkpoint.
+	progressed bool
 }
 
-func NewConsumer(brokers []string, topic, groupID string, handler func(cmd *OrderCommand) error, logger *zap.Logger) *Consumer {
+func NewConsumer(brokers []string, topic, groupID string, handler Handler, logger *zap.Logger) *Consumer {
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:  brokers,
 		Topic:    topic,
@@ -43,50 +138,485 @@ func NewConsumer(brokers []string, topic, groupID string, handler func(cmd *Orde
 
 	return &Consumer{
 		reader:  reader,
+		brokers: brokers,
+		topic:   topic,
+		groupID: groupID,
 		handler: handler,
 		logger:  logger,
+		offsets: make(map[int]int64),
+		workers: 1,
 	}
 }
 
-func (c *Consumer) Start(ctx context.Context) error {
-	c.logger.Info("Starting Kafka consumer")
+// SetDeadLetter registers fn to receive messages that cannot be decoded or
+// whose handler fails. Without one they are logged and skipped.
+func (c *Consumer) SetDeadLetter(fn DeadLetterFunc) {
+	c.deadLetter = fn
+}
+
+// SetWorkers spreads commands over n workers, with schedule picking the ones
+// that must run alone.
+func (c *Consumer) SetWorkers(n int, schedule ScheduleFunc) {
+	c.workers = max(n, 1)
+	c.schedule = schedule
+}
+
+// SetCheckpoint registers fn to be called about once per interval, while no
+// command is being processed, with the next offset to consume for every
+// partition seen so far.
+func (c *Consumer) SetCheckpoint(interval time.Duration, fn CheckpointFunc) {
+	c.checkpoint = fn
+	c.checkpointInterval = interval
+}
+
+func (c *Consumer) Offsets() map[int]int64 {
+	offsets := make(map[int]int64, len(c.offsets))
+	for partition, offset := range c.offsets {
+		offsets[partition] = offset
+	}
+	return offsets
+}
+
+// Replay feeds the handler every message of every partition from the given
+// offsets up to the consumer group's committed offset, or up to until if
+// that is further. A partition missing from from, as every partition is
+// before the first snapshot, is replayed from its earliest offset, which must
+// be 0: if retention has dropped messages before the first offset to replay,
+// Replay fails with ErrHistoryLost rather than build incomplete books. Past the
+// committed offset the group will deliver messages again, so replay stops at
+// the first one without a CommandID, which could not be recognised as a
+// duplicate. It must run before Start so that the group resumes where the
+// committed offsets point. The committed offsets are returned; Offsets then
+// holds where replay stopped.
+func (c *Consumer) Replay(ctx context.Context, from, until map[int]int64) (map[int]int64, error) {
+	client := &kafka.Client{Addr: kafka.TCP(c.brokers...)}
+
+	first, err := c.firstOffsets(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make([]int, 0, len(first))
+	for partition := range first {
+		partitions = append(partitions, partition)
+	}
+	sort.Ints(partitions)
+
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: c.groupID,
+		Topics:  map[string][]int{c.topic: partitions},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetch committed offsets: %w", err)
+	}
+
+	committedOffsets := make(map[int]int64, len(partitions))
+	for _, p := range committed.Topics[c.topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("fetch committed offset for partition %d: %w", p.Partition, p.Error)
+		}
+		committedOffsets[p.Partition] = p.CommittedOffset
+	}
+
+	for _, partition := range partitions {
+		start, end, err := replayRange(partition, first[partition], from, committedOffsets, until)
+		if err != nil {
+			return nil, err
+		}
+		c.offsets[partition] = start
+		if end <= start {
+			continue
+		}
+
+		c.logger.Info("Replaying partition",
+			zap.Int("partition", partition),
+			zap.Int64("from", start),
+			zap.Int64("to", end))
+
+		if err := c.replayPartition(ctx, partition, start, end, committedOffsets[partition]); err != nil {
+			return nil, err
+		}
+	}
+
+	return committedOffsets, nil
+}
+
+// replayRange returns the offsets of partition to replay: from its offset in
+// from, or from 0 if it has none, up to committed or until, whichever is
+// further. first is the earliest offset the partition still holds; a start
+// before it is an ErrHistoryLost.
+func replayRange(partition int, first int64, from, committed, until map[int]int64) (start, end int64, err error) {
+	start = from[partition]
+	if start < first {
+		return 0, 0, fmt.Errorf("%w: partition %d starts at offset %d, replay needs %d", ErrHistoryLost, partition, first, start)
+	}
+	end = committed[partition]
+	if until[partition] > end {
+		end = until[partition]
+	}
+	return start, end, nil
+}
+
+// firstOffsets returns the earliest offset still held by every partition of
+// the topic.
+func (c *Consumer) firstOffsets(ctx context.Context, client *kafka.Client) (map[int]int64, error) {
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{c.topic}})
+	if err != nil {
+		return nil, fmt.Errorf("fetch partitions: %w", err)
+	}
+
+	var requests []kafka.OffsetRequest
+	for _, topic := range meta.Topics {
+		if topic.Name != c.topic {
+			continue
+		}
+		if topic.Error != nil {
+			return nil, fmt.Errorf("fetch partitions: %w", topic.Error)
+		}
+		for _, p := range topic.Partitions {
+			requests = append(requests, kafka.FirstOffsetOf(p.ID))
+		}
+	}
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("topic %q has no partitions", c.topic)
+	}
+
+	listed, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{c.topic: requests},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetch first offsets: %w", err)
+	}
+
+	first := make(map[int]int64, len(requests))
+	for _, p := range listed.Topics[c.topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("fetch first offset for partition %d: %w", p.Partition, p.Error)
+		}
+		first[p.Partition] = p.FirstOffset
+	}
+	return first, nil
+}
+
+func (c *Consumer) replayPartition(ctx context.Context, partition int, start, end, committed int64) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   c.brokers,
+		Topic:     c.topic,
+		Partition: partition,
+		MinBytes:  1,
+		MaxBytes:  10e6,
+	})
+	defer reader.Close()
+
+	if err := reader.SetOffset(start); err != nil {
+		return err
+	}
 
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("replay partition %d: %w", partition, err)
+		}
+		if msg.Offset >= end {
+			return nil
+		}
+		if cmd := identify(msg.Value); msg.Offset >= committed && (cmd == nil || cmd.CommandID == "") {
+			c.logger.Warn("Stopping replay at command without commandId",
+				zap.Int("partition", partition),
+				zap.Int64("offset", msg.Offset))
+			return nil
+		}
+
+		if err := c.process(ctx, msg); err != nil {
+			return err
+		}
+
+		if msg.Offset+1 >= end {
+			return nil
+		}
+	}
+}
+
+// process runs msg to completion. It only returns an error if the message
+// must not be treated as consumed.
+func (c *Consumer) process(ctx context.Context, msg kafka.Message) error {
+	cmd, err := c.decode(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if cmd != nil {
+		if c.schedule != nil {
+			c.schedule(msg.Key, cmd, position(msg))
+		}
+		if err := c.run(ctx, msg, cmd); err != nil {
+			return err
+		}
+	}
+
+	c.offsets[msg.Partition] = msg.Offset + 1
+	return nil
+}
+
+// decode reads the command in msg. A message that is not a command is dead
+// lettered and a nil command returned.
+func (c *Consumer) decode(ctx context.Context, msg kafka.Message) (*OrderCommand, error) {
+	var cmd OrderCommand
+	if err := json.Unmarshal(msg.Value, &cmd); err != nil {
+		c.logger.Error("Failed to unmarshal message", zap.Error(err))
+		return nil, c.reject(ctx, msg, position(msg), identify(msg.Value), fmt.Errorf("%w: %v", ErrMalformed, err))
+	}
+	return &cmd, nil
+}
+
+// run calls the handler for cmd and dead letters it if the handler fails.
+func (c *Consumer) run(ctx context.Context, msg kafka.Message, cmd *OrderCommand) error {
+	pos := position(msg)
+	if err := c.handler(ctx, cmd, pos); err != nil {
+		if errors.Is(err, ErrStop) || ctx.Err() != nil {
+			return err
+		}
+		c.logger.Error("Failed to process command",
+			zap.String("commandId", cmd.CommandID),
+			zap.Error(err))
+		return c.reject(ctx, msg, pos, cmd, err)
+	}
+	return nil
+}
+
+func position(msg kafka.Message) Position {
+	return Position{Partition: msg.Partition, Offset: msg.Offset}
+}
+
+func (c *Consumer) reject(ctx context.Context, msg kafka.Message, pos Position, cmd *OrderCommand, cause error) error {
+	if c.deadLetter == nil {
+		return nil
+	}
+	return c.deadLetter(ctx, &DeadLetter{
+		Position: pos,
+		Key:      msg.Key,
+		Value:    msg.Value,
+		Time:     msg.Time,
+		Command:  cmd,
+		Err:      cause,
+	})
+}
+
+// identify reads the identifying fields of a command that failed to decode.
+// Fields of the wrong type are skipped rather than failing the whole decode.
+func identify(value []byte) *OrderCommand {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil
+	}
+
+	str := func(key string) string {
+		var s string
+		json.Unmarshal(fields[key], &s)
+		return s
+	}
+
+	cmd := &OrderCommand{
+		CommandID: str("commandId"),
+		OrderID:   str("orderId"),
+		UserID:    str("userId"),
+		Symbol:    str("symbol"),
+		Type:      str("type"),
+	}
+	if *cmd == (OrderCommand{}) {
+		return nil
+	}
+	return cmd
+}
+
+type job struct {
+	msg kafka.Message
+	cmd *OrderCommand
+}
+
+type result struct {
+	msg kafka.Message
+	err error
+}
+
+// Start reads commands until ctx is cancelled or a command fails in a way
+// that must stop the consumer. Commands run on the workers set by
+// SetWorkers, and a partition's offset is only committed once every command
+// before it has finished.
+func (c *Consumer) Start(ctx context.Context) error {
+	c.logger.Info("Starting Kafka consumer", zap.Int("workers", c.workers))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fetched := make(chan kafka.Message)
+	go func() {
+		for {
 			msg, err := c.reader.FetchMessage(ctx)
 			if err != nil {
 				if ctx.Err() != nil {
-					return ctx.Err()
+					return
 				}
 				c.logger.Error("Failed to fetch message", zap.Error(err))
 				continue
 			}
+			select {
+			case fetched <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make(chan result, c.workers*workerQueue)
+	queues := make([]chan job, c.workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan job, workerQueue)
+		wg.Add(1)
+		go func(queue <-chan job) {
+			defer wg.Done()
+			for j := range queue {
+				results <- result{msg: j.msg, err: c.run(ctx, j.msg, j.cmd)}
+			}
+		}(queues[i])
+	}
+
+	tracker := newOffsetTracker()
+
+	// On the way out the workers finish what they were given, so that every
+	// command that reached the book was also journaled.
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+		for r := range results {
+			if r.err == nil {
+				c.finish(context.Background(), tracker, r.msg, false)
+			}
+		}
+	}()
 
-			var cmd OrderCommand
-			if err := json.Unmarshal(msg.Value, &cmd); err != nil {
-				c.logger.Error("Failed to unmarshal message", zap.Error(err))
-				if err := c.reader.CommitMessages(ctx, msg); err != nil {
-					c.logger.Error("Failed to commit message", zap.Error(err))
+	collect := func(r result) error {
+		if r.err != nil {
+			return r.err
+		}
+		c.finish(ctx, tracker, r.msg, true)
+		return nil
+	}
+
+	// drain waits until no command is being processed.
+	drain := func() error {
+		for tracker.inFlight > 0 {
+			select {
+			case r := <-results:
+				if err := collect(r); err != nil {
+					return err
 				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+
+	var checkpoints <-chan time.Time
+	if c.checkpoint != nil {
+		ticker := time.NewTicker(c.checkpointInterval)
+		defer ticker.Stop()
+		checkpoints = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case r := <-results:
+			if err := collect(r); err != nil {
+				return err
+			}
+
+		case <-checkpoints:
+			if !c.progressed {
 				continue
 			}
+			if err := drain(); err != nil {
+				return err
+			}
+			if err := c.checkpoint(c.Offsets()); err != nil {
+				c.logger.Error("Failed to write checkpoint", zap.Error(err))
+				continue
+			}
+			c.progressed = false
 
-			if err := c.handler(&cmd); err != nil {
-				c.logger.Error("Failed to process command",
-					zap.String("commandId", cmd.CommandID),
-					zap.Error(err))
+		case msg := <-fetched:
+			cmd, err := c.decode(ctx, msg)
+			if err != nil {
+				return err
 			}
+			tracker.start(msg.Partition, msg.Offset)
 
-			if err := c.reader.CommitMessages(ctx, msg); err != nil {
-				c.logger.Error("Failed to commit message", zap.Error(err))
+			if cmd == nil {
+				c.finish(ctx, tracker, msg, true)
+				continue
+			}
+
+			if c.schedule == nil || c.schedule(msg.Key, cmd, position(msg)) {
+				if err := drain(); err != nil {
+					return err
+				}
+				if err := collect(result{msg: msg, err: c.run(ctx, msg, cmd)}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			queue := queues[shard(msg.Key, c.workers)]
+		enqueue:
+			for {
+				select {
+				case queue <- job{msg: msg, cmd: cmd}:
+					break enqueue
+				case r := <-results:
+					if err := collect(r); err != nil {
+						return err
+					}
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 			}
 		}
 	}
 }
 
+// finish records that msg has been processed and, if that moves its
+// partition's offset, commits the new offset.
+func (c *Consumer) finish(ctx context.Context, tracker *offsetTracker, msg kafka.Message, commit bool) {
+	next, advanced := tracker.finish(msg.Partition, msg.Offset)
+	if !advanced {
+		return
+	}
+	c.offsets[msg.Partition] = next
+	c.progressed = true
+
+	if !commit {
+		return
+	}
+	if err := c.reader.CommitMessages(ctx, kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: next - 1}); err != nil {
+		c.logger.Error("Failed to commit message", zap.Error(err))
+	}
+}
+
+func shard(key []byte, workers int) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(workers))
+}
+
 func (c *Consumer) Close() error {
 	return c.reader.Close()
 }
@@ -0,0 +1,109 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReplayRange(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		partition  int
+		first      int64
+		from       map[int]int64
+		committed  map[int]int64
+		until      map[int]int64
+		start, end int64
+		err        error
+	}{
+		{
+			name:      "no snapshot replays from the earliest offset",
+			partition: 0,
+			committed: map[int]int64{0: 120},
+			start:     0, end: 120,
+		},
+		{
+			name:      "no snapshot after retention dropped old messages",
+			partition: 1,
+			first:     40,
+			committed: map[int]int64{1: 90},
+			err:       ErrHistoryLost,
+		},
+		{
+			name:      "snapshot older than retention",
+			partition: 0,
+			first:     105,
+			from:      map[int]int64{0: 100},
+			committed: map[int]int64{0: 110},
+			err:       ErrHistoryLost,
+		},
+		{
+			name:      "snapshot at the first retained offset",
+			partition: 0,
+			first:     100,
+			from:      map[int]int64{0: 100},
+			committed: map[int]int64{0: 110},
+			start:     100, end: 110,
+		},
+		{
+			name:      "no snapshot and nothing committed replays the journal",
+			partition: 0,
+			committed: map[int]int64{0: -1},
+			until:     map[int]int64{0: 7},
+			start:     0, end: 7,
+		},
+		{
+			name:      "partition missing from the snapshot",
+			partition: 2,
+			from:      map[int]int64{0: 100, 1: 80},
+			committed: map[int]int64{0: 110, 1: 80, 2: 30},
+			start:     0, end: 30,
+		},
+		{
+			name:      "partition missing from the snapshot after retention",
+			partition: 2,
+			first:     5,
+			from:      map[int]int64{0: 100, 1: 80},
+			committed: map[int]int64{0: 110, 1: 80, 2: 30},
+			err:       ErrHistoryLost,
+		},
+		{
+			name:      "snapshot offset up to committed",
+			partition: 0,
+			from:      map[int]int64{0: 100},
+			committed: map[int]int64{0: 110},
+			start:     100, end: 110,
+		},
+		{
+			name:      "journal past committed",
+			partition: 0,
+			from:      map[int]int64{0: 100},
+			committed: map[int]int64{0: 110},
+			until:     map[int]int64{0: 115},
+			start:     100, end: 115,
+		},
+		{
+			name:      "nothing to replay",
+			partition: 0,
+			from:      map[int]int64{0: 110},
+			committed: map[int]int64{0: 110},
+			start:     110, end: 110,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end, err := replayRange(tc.partition, tc.first, tc.from, tc.committed, tc.until)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("got %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != tc.start || end != tc.end {
+				t.Fatalf("got [%d, %d), want [%d, %d)", start, end, tc.start, tc.end)
+			}
+		})
+	}
+}
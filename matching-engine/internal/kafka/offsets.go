@@ -0,0 +1,45 @@
+package kafka
+
+// offsetTracker follows the commands of each partition that are being
+// processed, in the order they were read. Commands finish out of order across
+// workers, but a partition's offset only moves past a command once every
+// command before it has finished too.
+type offsetTracker struct {
+	partitions map[int]*partitionOffsets
+	inFlight   int
+}
+
+type partitionOffsets struct {
+	queue    []int64
+	finished map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[int]*partitionOffsets)}
+}
+
+func (t *offsetTracker) start(partition int, offset int64) {
+	p, ok := t.partitions[partition]
+	if !ok {
+		p = &partitionOffsets{finished: make(map[int64]bool)}
+		t.partitions[partition] = p
+	}
+	p.queue = append(p.queue, offset)
+	t.inFlight++
+}
+
+// finish marks offset done. If that completes a run of commands at the front
+// of the partition, it returns the offset after them and true.
+func (t *offsetTracker) finish(partition int, offset int64) (int64, bool) {
+	p := t.partitions[partition]
+	p.finished[offset] = true
+	t.inFlight--
+
+	next, advanced := int64(0), false
+	for len(p.queue) > 0 && p.finished[p.queue[0]] {
+		delete(p.finished, p.queue[0])
+		next, advanced = p.queue[0]+1, true
+		p.queue = p.queue[1:]
+	}
+	return next, advanced
+}
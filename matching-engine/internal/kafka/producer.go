@@ -3,59 +3,99 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
 )
 
+const (
+	TopicTrades           = "trades"
+	TopicOrderbookUpdates = "orderbook-updates"
+	TopicOrderUpdates     = "order-updates"
+	TopicDeadLetter       = "orders-dlq"
+
+	// TopicOrderbookSnapshots is compacted and keyed by symbol, so it keeps
+	// the latest full book of every symbol.
+	TopicOrderbookSnapshots = "orderbook-snapshots"
+)
+
+// OutboundMessage is an encoded event that has not been written yet.
+type OutboundMessage struct {
+	Topic string          `json:"topic"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Writer writes messages to one topic. *kafka.Writer is one.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// topics are the topics a Producer writes, in the order Close closes them.
+var topics = []string{TopicTrades, TopicOrderbookUpdates, TopicOrderUpdates, TopicDeadLetter, TopicOrderbookSnapshots}
+
 type Producer struct {
-	tradeWriter     *kafka.Writer
-	orderbookWriter *kafka.Writer
-	logger          *zap.Logger
+	writers map[string]Writer
+	logger  *zap.Logger
 }
 
 func NewProducer(brokers []string, logger *zap.Logger) *Producer {
-	tradeWriter := &kafka.Writer{
-		Addr:         kafka.TCP(brokers...),
-		Topic:        "trades",
-		Balancer:     &kafka.Hash{},
-		BatchTimeout: 10 * time.Millisecond,
-		RequiredAcks: kafka.RequireOne,
-	}
-
-	orderbookWriter := &kafka.Writer{
-		Addr:         kafka.TCP(brokers...),
-		Topic:        "orderbook-updates",
-		Balancer:     &kafka.Hash{},
-		BatchTimeout: 10 * time.Millisecond,
-		RequiredAcks: kafka.RequireOne,
+	writers := make(map[string]Writer, len(topics))
+	for _, topic := range topics {
+		writers[topic] = &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 10 * time.Millisecond,
+			RequiredAcks: kafka.RequireOne,
+		}
 	}
+	return NewProducerWithWriters(writers, logger)
+}
 
-	return &Producer{
-		tradeWriter:     tradeWriter,
-		orderbookWriter: orderbookWriter,
-		logger:          logger,
-	}
+// NewProducerWithWriters returns a producer that writes each topic with its
+// writer in writers, such as a test double.
+func NewProducerWithWriters(writers map[string]Writer, logger *zap.Logger) *Producer {
+	return &Producer{writers: writers, logger: logger}
 }
 
 type TradeEvent struct {
-	TradeID      string `json:"tradeId"`
-	Symbol       string `json:"symbol"`
-	Price        string `json:"price"`
-	Quantity     string `json:"quantity"`
-	QuoteQty     string `json:"quoteQty"`
-	MakerOrderID string `json:"makerOrderId"`
-	TakerOrderID string `json:"takerOrderId"`
-	MakerUserID  string `json:"makerUserId"`
-	TakerUserID  string `json:"takerUserId"`
-	IsBuyerMaker bool   `json:"isBuyerMaker"`
-	MakerFee     string `json:"makerFee"`
-	TakerFee     string `json:"takerFee"`
-	ExecutedAt   int64  `json:"executedAt"`
+	TradeID       string `json:"tradeId"`
+	Symbol        string `json:"symbol"`
+	Price         string `json:"price"`
+	Quantity      string `json:"quantity"`
+	QuoteQty      string `json:"quoteQty"`
+	MakerOrderID  string `json:"makerOrderId"`
+	TakerOrderID  string `json:"takerOrderId"`
+	MakerUserID   string `json:"makerUserId"`
+	TakerUserID   string `json:"takerUserId"`
+	IsBuyerMaker  bool   `json:"isBuyerMaker"`
+	MakerFee      string `json:"makerFee"`
+	MakerFeeAsset string `json:"makerFeeAsset"`
+	TakerFee      string `json:"takerFee"`
+	TakerFeeAsset string `json:"takerFeeAsset"`
+	ExecutedAt    int64  `json:"executedAt"`
 }
 
+// OrderbookUpdateEvent is numbered per symbol: Sequence is always
+// PrevSequence plus one, and PrevSequence is the Sequence of the symbol's
+// previous update. CheckSequence and SequenceTracker use this to find gaps.
 type OrderbookUpdateEvent struct {
+	Symbol       string      `json:"symbol"`
+	PrevSequence uint64      `json:"prevSequence"`
+	Sequence     uint64      `json:"sequence"`
+	Bids         [][2]string `json:"bids"`
+	Asks         [][2]string `json:"asks"`
+	Timestamp    int64       `json:"timestamp"`
+}
+
+// OrderbookSnapshotEvent is the full depth of a book. Sequence is that of
+// the last delta it includes.
+type OrderbookSnapshotEvent struct {
 	Symbol    string      `json:"symbol"`
 	Sequence  uint64      `json:"sequence"`
 	Bids      [][2]string `json:"bids"`
@@ -63,53 +103,112 @@ type OrderbookUpdateEvent struct {
 	Timestamp int64       `json:"timestamp"`
 }
 
-func (p *Producer) PublishTrade(ctx context.Context, trade *TradeEvent) error {
-	value, err := json.Marshal(trade)
-	if err != nil {
-		return err
-	}
+type OrderUpdateEvent struct {
+	OrderID      string `json:"orderId"`
+	UserID       string `json:"userId"`
+	Symbol       string `json:"symbol"`
+	Status       string `json:"status"`
+	FilledQty    string `json:"filledQty"`
+	RemainingQty string `json:"remainingQty"`
+	AvgPrice     string `json:"avgPrice"`
+	Reason       string `json:"reason,omitempty"`
+	Timestamp    int64  `json:"timestamp"`
+}
 
-	return p.tradeWriter.WriteMessages(ctx, kafka.Message{
-		Key:   []byte(trade.Symbol),
-		Value: value,
-	})
+// DeadLetterEvent carries an orders message that could not be processed.
+// Payload is the original message value, verbatim.
+type DeadLetterEvent struct {
+	CommandID string `json:"commandId,omitempty"`
+	OrderID   string `json:"orderId,omitempty"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Payload   string `json:"payload"`
+	Error     string `json:"error"`
+	Timestamp int64  `json:"timestamp"`
 }
 
-func (p *Producer) PublishTrades(ctx context.Context, trades []*TradeEvent) error {
-	if len(trades) == 0 {
-		return nil
-	}
+// PartialWriteError is returned by PublishMessages when some messages were
+// written before the error. Unsent holds the others, in order, so that a
+// retry does not write any message twice.
+type PartialWriteError struct {
+	Unsent []OutboundMessage
+	Err    error
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("%d messages unsent: %v", len(e.Unsent), e.Err)
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
 
-	messages := make([]kafka.Message, len(trades))
-	for i, trade := range trades {
-		value, err := json.Marshal(trade)
+func (p *Producer) PublishOrderUpdates(ctx context.Context, updates []*OrderUpdateEvent) error {
+	msgs := make([]OutboundMessage, len(updates))
+	for i, update := range updates {
+		value, err := json.Marshal(update)
 		if err != nil {
 			return err
 		}
-		messages[i] = kafka.Message{
-			Key:   []byte(trade.Symbol),
-			Value: value,
-		}
+		msgs[i] = OutboundMessage{Topic: TopicOrderUpdates, Key: update.Symbol, Value: value}
 	}
+	return p.PublishMessages(ctx, msgs)
+}
+
+// PublishMessages writes pre-encoded messages in order, batching consecutive
+// messages for the same topic. If a batch fails after others, or only some of
+// its messages fail, the error is a *PartialWriteError.
+func (p *Producer) PublishMessages(ctx context.Context, msgs []OutboundMessage) error {
+	for start := 0; start < len(msgs); {
+		end := start + 1
+		for end < len(msgs) && msgs[end].Topic == msgs[start].Topic {
+			end++
+		}
 
-	return p.tradeWriter.WriteMessages(ctx, messages...)
+		writer, ok := p.writers[msgs[start].Topic]
+		if !ok {
+			return partial(msgs, msgs[start:], fmt.Errorf("no writer for topic %q", msgs[start].Topic))
+		}
+
+		batch := make([]kafka.Message, 0, end-start)
+		for _, msg := range msgs[start:end] {
+			batch = append(batch, kafka.Message{Key: []byte(msg.Key), Value: msg.Value})
+		}
+		if err := writer.WriteMessages(ctx, batch...); err != nil {
+			rest := msgs[start:]
+			var failed kafka.WriteErrors
+			if errors.As(err, &failed) && len(failed) == len(batch) {
+				rest = nil
+				for i, msg := range msgs[start:end] {
+					if failed[i] != nil {
+						rest = append(rest, msg)
+					}
+				}
+				rest = append(rest, msgs[end:]...)
+			}
+			return partial(msgs, rest, err)
+		}
+
+		start = end
+	}
+	return nil
 }
 
-func (p *Producer) PublishOrderbookUpdate(ctx context.Context, update *OrderbookUpdateEvent) error {
-	value, err := json.Marshal(update)
-	if err != nil {
+// partial returns err, as a *PartialWriteError if some of msgs were written.
+func partial(msgs, unsent []OutboundMessage, err error) error {
+	if len(unsent) == len(msgs) {
 		return err
 	}
-
-	return p.orderbookWriter.WriteMessages(ctx, kafka.Message{
-		Key:   []byte(update.Symbol),
-		Value: value,
-	})
+	return &PartialWriteError{Unsent: unsent, Err: err}
 }
 
 func (p *Producer) Close() error {
-	if err := p.tradeWriter.Close(); err != nil {
-		return err
+	for _, topic := range topics {
+		if writer, ok := p.writers[topic]; ok {
+			if err := writer.Close(); err != nil {
+				return err
+			}
+		}
 	}
-	return p.orderbookWriter.Close()
+	return nil
 }
@@ -0,0 +1,112 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// fakeWriter fails its first calls with the errors in fail and keeps what
+// the others write. A kafka.WriteErrors only fails the messages it names.
+type fakeWriter struct {
+	fail    []error
+	written []string
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	var err error
+	if len(w.fail) > 0 {
+		err, w.fail = w.fail[0], w.fail[1:]
+	}
+	var failed kafka.WriteErrors
+	for i, msg := range msgs {
+		if err != nil && (!errors.As(err, &failed) || failed[i] != nil) {
+			continue
+		}
+		w.written = append(w.written, string(msg.Value))
+	}
+	return err
+}
+
+func (w *fakeWriter) Close() error { return nil }
+
+func outbound(topic string, values ...string) []OutboundMessage {
+	msgs := make([]OutboundMessage, len(values))
+	for i, value := range values {
+		msgs[i] = OutboundMessage{Topic: topic, Key: "BTC/USDT", Value: []byte(value)}
+	}
+	return msgs
+}
+
+func TestPublishMessagesReportsUnsent(t *testing.T) {
+	errDown := errors.New("broker down")
+
+	for _, tc := range []struct {
+		name   string
+		trades []error
+		orders []error
+		// unsent is what a PartialWriteError leaves, or "" if nothing was
+		// written.
+		unsent string
+	}{
+		{"all written", nil, nil, "[]"},
+		{"first topic fails", []error{errDown}, nil, ""},
+		{"second topic fails", nil, []error{errDown}, "[o1 o2 d1]"},
+		{"part of a batch fails", []error{kafka.WriteErrors{nil, errDown}}, nil, "[t2 o1 o2 d1]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewProducerWithWriters(map[string]Writer{
+				TopicTrades:       &fakeWriter{fail: tc.trades},
+				TopicOrderUpdates: &fakeWriter{fail: tc.orders},
+				TopicDeadLetter:   &fakeWriter{},
+			}, zap.NewNop())
+
+			msgs := append(outbound(TopicTrades, "t1", "t2"), outbound(TopicOrderUpdates, "o1", "o2")...)
+			msgs = append(msgs, outbound(TopicDeadLetter, "d1")...)
+			err := p.PublishMessages(context.Background(), msgs)
+
+			var partial *PartialWriteError
+			switch {
+			case tc.unsent == "[]":
+				if err != nil {
+					t.Fatal(err)
+				}
+			case tc.unsent == "":
+				if err != errDown {
+					t.Fatalf("got %v, want %v", err, errDown)
+				}
+			default:
+				if !errors.As(err, &partial) {
+					t.Fatalf("got %v, want a PartialWriteError", err)
+				}
+				var unsent []string
+				for _, msg := range partial.Unsent {
+					unsent = append(unsent, string(msg.Value))
+				}
+				if got := fmt.Sprint(unsent); got != tc.unsent {
+					t.Fatalf("unsent %s, want %s", got, tc.unsent)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishOrderUpdates(t *testing.T) {
+	w := &fakeWriter{}
+	p := NewProducerWithWriters(map[string]Writer{TopicOrderUpdates: w}, zap.NewNop())
+
+	err := p.PublishOrderUpdates(context.Background(), []*OrderUpdateEvent{
+		{OrderID: "o1", Symbol: "BTC/USDT", Status: "NEW"},
+		{OrderID: "o2", Symbol: "BTC/USDT", Status: "FILLED"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.written) != 2 || w.written[0] == w.written[1] {
+		t.Fatalf("wrote %v", w.written)
+	}
+}
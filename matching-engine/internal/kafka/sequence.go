@@ -0,0 +1,59 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrSequenceGap means updates were missed between the last one applied
+	// and this one. The book has to be rebuilt from a snapshot.
+	ErrSequenceGap = errors.New("orderbook update sequence gap")
+
+	// ErrStaleUpdate means the update is already reflected in the book, for
+	// example because it was delivered twice. It is safe to skip.
+	ErrStaleUpdate = errors.New("orderbook update already applied")
+)
+
+// CheckSequence reports whether update directly follows the update or
+// snapshot with sequence last. It returns nil if it does, ErrStaleUpdate if
+// it was already covered and an error wrapping ErrSequenceGap if updates in
+// between were missed.
+func CheckSequence(last uint64, update *OrderbookUpdateEvent) error {
+	switch {
+	case update.Sequence <= last:
+		return ErrStaleUpdate
+	case update.PrevSequence != last:
+		return fmt.Errorf("%w: %s after %d, update follows %d", ErrSequenceGap, update.Symbol, last, update.PrevSequence)
+	}
+	return nil
+}
+
+// SequenceTracker checks the updates of many books with CheckSequence. A
+// book starts at the first update seen for it, or at a snapshot given to
+// Reset.
+type SequenceTracker struct {
+	last map[string]uint64
+}
+
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{last: make(map[string]uint64)}
+}
+
+// Reset starts symbol again from a snapshot with the given sequence.
+func (t *SequenceTracker) Reset(symbol string, sequence uint64) {
+	t.last[symbol] = sequence
+}
+
+// Check checks update against the last update accepted for its symbol and
+// accepts it if it follows on. After a gap the book stays where it was
+// until Reset.
+func (t *SequenceTracker) Check(update *OrderbookUpdateEvent) error {
+	if last, ok := t.last[update.Symbol]; ok {
+		if err := CheckSequence(last, update); err != nil {
+			return err
+		}
+	}
+	t.last[update.Symbol] = update.Sequence
+	return nil
+}
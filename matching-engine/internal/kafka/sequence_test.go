@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+)
+
+func update(symbol string, prev, seq uint64) *OrderbookUpdateEvent {
+	return &OrderbookUpdateEvent{Symbol: symbol, PrevSequence: prev, Sequence: seq}
+}
+
+func TestCheckSequence(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		last   uint64
+		update *OrderbookUpdateEvent
+		want   error
+	}{
+		{"next in order", 7, update("BTC/USDT", 7, 8), nil},
+		{"first after a snapshot", 0, update("BTC/USDT", 0, 1), nil},
+		{"gap", 7, update("BTC/USDT", 9, 10), ErrSequenceGap},
+		{"duplicate", 8, update("BTC/USDT", 7, 8), ErrStaleUpdate},
+		{"stale", 12, update("BTC/USDT", 7, 8), ErrStaleUpdate},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckSequence(tc.last, tc.update)
+			if tc.want == nil && err != nil || tc.want != nil && !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSequenceTracker(t *testing.T) {
+	type step struct {
+		reset  bool
+		update *OrderbookUpdateEvent
+		want   error
+	}
+
+	for _, tc := range []struct {
+		name  string
+		steps []step
+	}{
+		{"in order", []step{
+			{update: update("BTC/USDT", 4, 5)},
+			{update: update("BTC/USDT", 5, 6)},
+			{update: update("BTC/USDT", 6, 7)},
+		}},
+		{"gap holds the book until reset", []step{
+			{update: update("BTC/USDT", 4, 5)},
+			{update: update("BTC/USDT", 6, 7), want: ErrSequenceGap},
+			{update: update("BTC/USDT", 7, 8), want: ErrSequenceGap},
+			{reset: true, update: update("BTC/USDT", 10, 11)},
+			{update: update("BTC/USDT", 11, 12)},
+		}},
+		{"duplicate and stale", []step{
+			{update: update("BTC/USDT", 4, 5)},
+			{update: update("BTC/USDT", 5, 6)},
+			{update: update("BTC/USDT", 5, 6), want: ErrStaleUpdate},
+			{update: update("BTC/USDT", 3, 4), want: ErrStaleUpdate},
+			{update: update("BTC/USDT", 6, 7)},
+		}},
+		{"first update after reset", []step{
+			{reset: true, update: update("BTC/USDT", 10, 11)},
+			{update: update("BTC/USDT", 11, 12)},
+		}},
+		{"update covered by the reset snapshot", []step{
+			{reset: true, update: update("BTC/USDT", 9, 10), want: ErrStaleUpdate},
+			{update: update("BTC/USDT", 10, 11)},
+		}},
+		{"update past the reset snapshot", []step{
+			{reset: true, update: update("BTC/USDT", 12, 13), want: ErrSequenceGap},
+		}},
+		{"symbols are independent", []step{
+			{update: update("BTC/USDT", 4, 5)},
+			{update: update("ETH/USDT", 90, 91)},
+			{update: update("BTC/USDT", 5, 6)},
+			{update: update("ETH/USDT", 92, 93), want: ErrSequenceGap},
+			{update: update("BTC/USDT", 6, 7)},
+			{update: update("ETH/USDT", 91, 92)},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := NewSequenceTracker()
+			for i, s := range tc.steps {
+				if s.reset {
+					// The snapshot a reset starts from has sequence 10.
+					tracker.Reset(s.update.Symbol, 10)
+				}
+				err := tracker.Check(s.update)
+				if s.want == nil && err != nil || s.want != nil && !errors.Is(err, s.want) {
+					t.Fatalf("step %d, %s %d->%d: got %v, want %v", i, s.update.Symbol, s.update.PrevSequence, s.update.Sequence, err, s.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// EnsureCompacted creates topic with log compaction unless it already
+// exists. Partitions and replication are left to the broker's defaults, and
+// an existing topic is not changed.
+func EnsureCompacted(ctx context.Context, brokers []string, topic string) error {
+	client := &kafka.Client{Addr: kafka.TCP(brokers...), Timeout: 10 * time.Second}
+	resp, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{{
+			Topic:             topic,
+			NumPartitions:     -1,
+			ReplicationFactor: -1,
+			ConfigEntries: []kafka.ConfigEntry{
+				{ConfigName: "cleanup.policy", ConfigValue: "compact"},
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if err := resp.Errors[topic]; err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+		return err
+	}
+	return nil
+}
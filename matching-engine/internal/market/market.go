@@ -0,0 +1,98 @@
+package market
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/opencode-exchange/matching-engine/internal/fees"
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+	"github.com/shopspring/decimal"
+)
+
+// Market mirrors the columns of the markets table the engine needs.
+type Market struct {
+	Symbol        string          `json:"symbol"`
+	BaseAsset     string          `json:"baseAsset"`
+	QuoteAsset    string          `json:"quoteAsset"`
+	PriceDecimals int32           `json:"priceDecimals"`
+	QtyDecimals   int32           `json:"qtyDecimals"`
+	TickSize      decimal.Decimal `json:"tickSize"`
+	StepSize      decimal.Decimal `json:"stepSize"`
+	MinQty        decimal.Decimal `json:"minQty"`
+	MaxQty        decimal.Decimal `json:"maxQty"`
+	MinNotional   decimal.Decimal `json:"minNotional"`
+	// PriceBandBps limits how far from PriceBandReference, LAST_TRADE (the
+	// default) or MID, a taker may trade. Zero disables the band.
+	PriceBandBps       int64  `json:"priceBandBps"`
+	PriceBandReference string `json:"priceBandReference"`
+	// STP is the self-trade prevention mode of orders that do not choose
+	// one, such as CANCEL_NEWEST. Empty means NONE.
+	STP      string          `json:"stp"`
+	MakerFee decimal.Decimal `json:"makerFee"`
+	TakerFee decimal.Decimal `json:"takerFee"`
+}
+
+func (m Market) Scale() orderbook.Scale {
+	return orderbook.Scale{PriceDecimals: m.PriceDecimals, QtyDecimals: m.QtyDecimals}
+}
+
+// Rules converts the market's limits to ticks and lots. Limits that are finer
+// than the market's decimals are an error.
+func (m Market) Rules() (matcher.MarketRules, error) {
+	scale := m.Scale()
+	rules := matcher.MarketRules{Symbol: m.Symbol, Scale: scale}
+
+	var err error
+	if rules.TickSize, err = scale.ToTicks(m.TickSize); err != nil {
+		return rules, fmt.Errorf("%s tickSize: %w", m.Symbol, err)
+	}
+	if rules.StepSize, err = scale.ToLots(m.StepSize); err != nil {
+		return rules, fmt.Errorf("%s stepSize: %w", m.Symbol, err)
+	}
+	if rules.MinQty, err = scale.ToLots(m.MinQty); err != nil {
+		return rules, fmt.Errorf("%s minQty: %w", m.Symbol, err)
+	}
+	if rules.MaxQty, err = scale.ToLots(m.MaxQty); err != nil {
+		return rules, fmt.Errorf("%s maxQty: %w", m.Symbol, err)
+	}
+	if rules.MinNotional, err = scale.ToQuote(m.MinNotional); err != nil {
+		return rules, fmt.Errorf("%s minNotional: %w", m.Symbol, err)
+	}
+	if rules.TickSize < 0 || rules.StepSize < 0 || rules.MinQty < 0 || rules.MaxQty < 0 || m.PriceBandBps < 0 {
+		return rules, fmt.Errorf("%s: negative limit", m.Symbol)
+	}
+
+	rules.PriceBandBps = m.PriceBandBps
+	switch m.PriceBandReference {
+	case "", matcher.BandLastTrade:
+		rules.PriceBandReference = matcher.BandLastTrade
+	case matcher.BandMid:
+		rules.PriceBandReference = matcher.BandMid
+	default:
+		return rules, fmt.Errorf("%s: unknown priceBandReference %q", m.Symbol, m.PriceBandReference)
+	}
+
+	if rules.STP, err = orderbook.ParseSelfTradePrevention(m.STP); err != nil {
+		return rules, fmt.Errorf("%s stp: %w", m.Symbol, err)
+	}
+	return rules, nil
+}
+
+func (m Market) Fees() fees.Rates {
+	return fees.Rates{Maker: m.MakerFee, Taker: m.TakerFee}
+}
+
+func Load(path string) ([]Market, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var markets []Market
+	if err := json.Unmarshal(data, &markets); err != nil {
+		return nil, err
+	}
+	return markets, nil
+}
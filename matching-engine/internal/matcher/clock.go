@@ -0,0 +1,96 @@
+package matcher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock gives the time the matcher puts on orders, trades, execution reports
+// and deltas in symbol's book. Books may be run by different goroutines, so
+// each has its own time.
+type Clock interface {
+	Now(symbol string) time.Time
+}
+
+// IDGenerator names the trades of a symbol.
+type IDGenerator interface {
+	TradeID(symbol string) string
+}
+
+// CommandClock is the time of the command each book is running, as given to
+// Set. It never reads the wall clock, so the same commands are always
+// stamped with the same times.
+type CommandClock struct {
+	mu    sync.Mutex
+	all   time.Time
+	books map[string]time.Time
+}
+
+func NewCommandClock() *CommandClock {
+	return &CommandClock{books: make(map[string]time.Time)}
+}
+
+// Set sets the time of symbol's book, or of every book if symbol is empty.
+func (c *CommandClock) Set(symbol string, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if symbol == "" {
+		c.all = t
+		clear(c.books)
+		return
+	}
+	c.books[symbol] = t
+}
+
+func (c *CommandClock) Now(symbol string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t, ok := c.books[symbol]; ok {
+		return t
+	}
+	return c.all
+}
+
+// SequentialIDs numbers the trades of each symbol from 1, as "BTC/USDT-1".
+type SequentialIDs struct {
+	mu   sync.Mutex
+	last map[string]uint64
+}
+
+func NewSequentialIDs() *SequentialIDs {
+	return &SequentialIDs{last: make(map[string]uint64)}
+}
+
+func (s *SequentialIDs) TradeID(symbol string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last[symbol]++
+	return fmt.Sprintf("%s-%d", symbol, s.last[symbol])
+}
+
+// Last returns the number of the last trade of each symbol.
+func (s *SequentialIDs) Last() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := make(map[string]uint64, len(s.last))
+	for symbol, n := range s.last {
+		last[symbol] = n
+	}
+	return last
+}
+
+// Restore continues numbering after the given last trades.
+func (s *SequentialIDs) Restore(last map[string]uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last = make(map[string]uint64, len(last))
+	for symbol, n := range last {
+		s.last[symbol] = n
+	}
+}
@@ -0,0 +1,175 @@
+package matcher
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+)
+
+// These tests are meant to be run with -race.
+
+var concurrencySymbols = []string{"BTC/USDT", "ETH/USDT", "SOL/USDT"}
+
+func newConcurrencyMatcher() *Matcher {
+	m := NewMatcher(nil, nil)
+	for _, symbol := range concurrencySymbols {
+		m.AddMarket(MarketRules{Symbol: symbol, Scale: orderbook.DefaultScale})
+	}
+	return m
+}
+
+// writeOrders sends symbol a mix of resting orders, trades, icebergs,
+// stops, amends and cancels.
+func writeOrders(m *Matcher, symbol string, n int) {
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("%s-%d", symbol, i)
+		user := fmt.Sprintf("u%d", i%4)
+		side := orderbook.Side(i % 2)
+		price := int64(1000 + (i*37)%21 - 10)
+		if side == orderbook.Sell {
+			price += 5
+		}
+
+		order := orderbook.NewOrder(id, user, symbol, side, orderbook.Limit, price, int64(1+i%7))
+		switch i % 11 {
+		case 3:
+			order.DisplayQty = 1
+		case 5:
+			order.Type = orderbook.Market
+			order.Price = 0
+		case 7:
+			order.Type = orderbook.StopLimit
+			order.StopPrice = price
+		}
+		m.ProcessOrder(order)
+
+		switch i % 13 {
+		case 4:
+			m.CancelOrder(symbol, fmt.Sprintf("%s-%d", symbol, i-4))
+		case 8:
+			m.AmendOrder(symbol, fmt.Sprintf("%s-%d", symbol, i-1), price-1, 0)
+		case 12:
+			m.CancelAll(CancelFilter{UserID: user, Symbol: symbol}, ReasonMassCancel)
+		}
+	}
+}
+
+// checkView fails if view is not a consistent book.
+func checkView(t *testing.T, view *orderbook.View) {
+	orders := 0
+	for _, side := range []struct {
+		levels orderbook.LevelViews
+		better func(a, b int64) bool
+	}{
+		{view.Bids, func(a, b int64) bool { return a > b }},
+		{view.Asks, func(a, b int64) bool { return a < b }},
+	} {
+		var prev *orderbook.LevelView
+		side.levels.Walk(func(level *orderbook.LevelView) bool {
+			if prev != nil && !side.better(prev.Price, level.Price) {
+				t.Errorf("%s: level %d after %d", view.Symbol, level.Price, prev.Price)
+			}
+			var volume int64
+			for _, order := range level.Orders {
+				volume += order.Visible()
+			}
+			if volume != level.Volume || len(level.Orders) == 0 {
+				t.Errorf("%s: level %d volume %d with %d orders showing %d", view.Symbol, level.Price, level.Volume, len(level.Orders), volume)
+			}
+			orders += len(level.Orders)
+			prev = level
+			return true
+		})
+	}
+
+	if bid, ask := view.Bids.Best(), view.Asks.Best(); bid != nil && ask != nil && bid.Price >= ask.Price {
+		t.Errorf("%s: crossed at sequence %d: bid %d ask %d", view.Symbol, view.Sequence, bid.Price, ask.Price)
+	}
+	if orders != view.Orders {
+		t.Errorf("%s: %d orders in levels, view counts %d", view.Symbol, orders, view.Orders)
+	}
+}
+
+func TestViewsWhileMatching(t *testing.T) {
+	m := newConcurrencyMatcher()
+
+	var done atomic.Bool
+	var writers, readers sync.WaitGroup
+	for _, symbol := range concurrencySymbols {
+		writers.Add(1)
+		go func(symbol string) {
+			defer writers.Done()
+			writeOrders(m, symbol, 3000)
+		}(symbol)
+	}
+
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			last := make(map[string]uint64)
+			for !done.Load() {
+				for _, symbol := range concurrencySymbols {
+					view := m.View(symbol)
+					if view.Sequence < last[symbol] {
+						t.Errorf("%s: sequence went back from %d to %d", symbol, last[symbol], view.Sequence)
+					}
+					last[symbol] = view.Sequence
+					checkView(t, view)
+					view.Depth(5)
+					view.Order(fmt.Sprintf("%s-%d", symbol, view.Sequence%100))
+				}
+			}
+		}()
+	}
+
+	writers.Wait()
+	done.Store(true)
+	readers.Wait()
+
+	for _, symbol := range concurrencySymbols {
+		view := m.View(symbol)
+		checkView(t, view)
+		if ob := m.GetOrderbook(symbol); view.Sequence != ob.Sequence || view.Orders != len(ob.Orders) {
+			t.Errorf("%s: last view at sequence %d with %d orders, book at %d with %d",
+				symbol, view.Sequence, view.Orders, ob.Sequence, len(ob.Orders))
+		}
+	}
+}
+
+func TestViewsAreImmutable(t *testing.T) {
+	m := newConcurrencyMatcher()
+	writeOrders(m, "BTC/USDT", 500)
+
+	view := m.View("BTC/USDT")
+	bids, asks := view.Depth(1 << 20)
+	want := fmt.Sprint(bids, asks, view.Sequence, view.Orders)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writeOrders(m, "BTC/USDT", 2000)
+	}()
+	for i := 0; i < 100; i++ {
+		bids, asks := view.Depth(1 << 20)
+		if got := fmt.Sprint(bids, asks, view.Sequence, view.Orders); got != want {
+			t.Fatalf("view changed under a writer")
+		}
+	}
+	wg.Wait()
+
+	if m.View("BTC/USDT") == view {
+		t.Fatal("no new view was published")
+	}
+}
+
+func TestViewOfUnknownSymbol(t *testing.T) {
+	m := newConcurrencyMatcher()
+	if view := m.View("DOGE/USDT"); view != nil {
+		t.Fatalf("got %+v", view)
+	}
+}
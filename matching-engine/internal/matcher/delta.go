@@ -0,0 +1,67 @@
+package matcher
+
+import (
+	"sort"
+
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+)
+
+// touchedLevels collects the price levels a command changed; the delta
+// reports what each holds once the command is done, 0 if it is gone. Every
+// command builds its delta this way, so a level is never reported from
+// what the command assumed about it.
+type touchedLevels struct {
+	bids map[int64]struct{}
+	asks map[int64]struct{}
+}
+
+func newTouchedLevels() *touchedLevels {
+	return &touchedLevels{
+		bids: make(map[int64]struct{}),
+		asks: make(map[int64]struct{}),
+	}
+}
+
+func (t *touchedLevels) add(side orderbook.Side, price int64) {
+	if side == orderbook.Buy {
+		t.bids[price] = struct{}{}
+	} else {
+		t.asks[price] = struct{}{}
+	}
+}
+
+// delta takes the book's next sequence number, or returns nil if no level
+// was touched.
+func (t *touchedLevels) delta(ob *orderbook.Orderbook) *OrderbookDelta {
+	if len(t.bids) == 0 && len(t.asks) == 0 {
+		return nil
+	}
+	prev, seq := ob.NextSequence()
+	return &OrderbookDelta{
+		Symbol:       ob.Symbol,
+		PrevSequence: prev,
+		Sequence:     seq,
+		Bids:         levelVolumes(ob.Bids, t.bids, true),
+		Asks:         levelVolumes(ob.Asks, t.asks, false),
+	}
+}
+
+// levelVolumes lists the levels at prices best first, so the same command
+// always gives the same delta.
+func levelVolumes(side *orderbook.BookSide, prices map[int64]struct{}, descending bool) [][2]int64 {
+	levels := make([][2]int64, 0, len(prices))
+	for price := range prices {
+		if level := side.GetLevel(price); level != nil {
+			levels = append(levels, [2]int64{price, level.Volume})
+		} else {
+			levels = append(levels, [2]int64{price, 0})
+		}
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if descending {
+			return levels[i][0] > levels[j][0]
+		}
+		return levels[i][0] < levels[j][0]
+	})
+	return levels
+}
@@ -0,0 +1,153 @@
+package matcher
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+)
+
+const deltaSymbol = "BTC/USDT"
+
+// replica is a downstream book built only from deltas.
+type replica struct {
+	bids, asks map[int64]int64
+	sequence   uint64
+}
+
+func newReplica() *replica {
+	return &replica{bids: make(map[int64]int64), asks: make(map[int64]int64)}
+}
+
+func (r *replica) apply(delta *OrderbookDelta) error {
+	if delta.PrevSequence != r.sequence || delta.Sequence != r.sequence+1 {
+		return fmt.Errorf("delta %d->%d after %d", delta.PrevSequence, delta.Sequence, r.sequence)
+	}
+	r.sequence = delta.Sequence
+
+	for _, side := range []struct {
+		book   map[int64]int64
+		levels [][2]int64
+	}{{r.bids, delta.Bids}, {r.asks, delta.Asks}} {
+		for _, level := range side.levels {
+			if level[1] == 0 {
+				delete(side.book, level[0])
+			} else {
+				side.book[level[0]] = level[1]
+			}
+		}
+	}
+	return nil
+}
+
+func (r *replica) depth() (bids, asks [][2]int64) {
+	levels := func(book map[int64]int64, better func(a, b int64) bool) [][2]int64 {
+		out := make([][2]int64, 0, len(book))
+		for price, volume := range book {
+			out = append(out, [2]int64{price, volume})
+		}
+		sort.Slice(out, func(i, j int) bool { return better(out[i][0], out[j][0]) })
+		return out
+	}
+	return levels(r.bids, func(a, b int64) bool { return a > b }),
+		levels(r.asks, func(a, b int64) bool { return a < b })
+}
+
+// randomCommand runs one random command against m. Prices cluster around
+// 1000 so that orders rest on several levels, often behind others, and
+// takers regularly cross.
+func randomCommand(rng *rand.Rand, m *Matcher, i int, live *[]string) []*MatchResult {
+	pick := func() string {
+		if len(*live) == 0 {
+			return "missing"
+		}
+		return (*live)[rng.Intn(len(*live))]
+	}
+
+	switch n := rng.Intn(20); {
+	case n < 3:
+		return one(m.CancelOrder(deltaSymbol, pick()))
+	case n < 5:
+		price := int64(0)
+		if rng.Intn(2) == 0 {
+			price = int64(990 + rng.Intn(21))
+		}
+		return one(m.AmendOrder(deltaSymbol, pick(), price, int64(1+rng.Intn(10))))
+	case n < 6:
+		side := orderbook.Side(rng.Intn(2))
+		return m.CancelAll(CancelFilter{UserID: fmt.Sprintf("u%d", rng.Intn(5)), Side: &side}, ReasonMassCancel)
+	}
+
+	side := orderbook.Side(rng.Intn(2))
+	price := int64(990 + rng.Intn(21))
+	order := orderbook.NewOrder(fmt.Sprintf("o%d", i), fmt.Sprintf("u%d", rng.Intn(5)), deltaSymbol, side, orderbook.Limit, price, int64(1+rng.Intn(10)))
+	switch rng.Intn(12) {
+	case 0:
+		order.Type, order.Price = orderbook.Market, 0
+	case 1:
+		order.TimeInForce = orderbook.IOC
+	case 2:
+		order.TimeInForce = orderbook.FOK
+	case 3:
+		order.TimeInForce = orderbook.PostOnly
+	case 4:
+		order.DisplayQty = 1 + rng.Int63n(order.Quantity)
+	case 5:
+		order.Type, order.StopPrice = orderbook.StopLimit, int64(990+rng.Intn(21))
+	case 6:
+		order.STP = orderbook.SelfTradePrevention(1 + rng.Intn(5))
+	}
+	*live = append(*live, order.ID)
+	return one(m.ProcessOrder(order))
+}
+
+func one(result *MatchResult) []*MatchResult {
+	if result == nil {
+		return nil
+	}
+	return []*MatchResult{result}
+}
+
+func TestDeltasRebuildBook(t *testing.T) {
+	for seed := int64(1); seed <= 50; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		m := NewMatcher(nil, nil)
+		m.AddMarket(MarketRules{Symbol: deltaSymbol, Scale: orderbook.DefaultScale})
+		ob := m.GetOrderbook(deltaSymbol)
+		downstream := newReplica()
+		var live []string
+
+		for i := 0; i < 2000; i++ {
+			for _, result := range randomCommand(rng, m, i, &live) {
+				if result.OrderbookDelta == nil {
+					continue
+				}
+				if err := downstream.apply(result.OrderbookDelta); err != nil {
+					t.Fatalf("seed %d, command %d: %v", seed, i, err)
+				}
+			}
+
+			wantBids, wantAsks := ob.GetDepth(1 << 20)
+			gotBids, gotAsks := downstream.depth()
+			if fmt.Sprint(gotBids, gotAsks) != fmt.Sprint(wantBids, wantAsks) {
+				t.Fatalf("seed %d, command %d: deltas give bids %v asks %v, book has bids %v asks %v",
+					seed, i, gotBids, gotAsks, wantBids, wantAsks)
+			}
+		}
+	}
+}
+
+func TestCancelBehindBestReportsRemainingVolume(t *testing.T) {
+	m := NewMatcher(nil, nil)
+	m.AddMarket(MarketRules{Symbol: deltaSymbol, Scale: orderbook.DefaultScale})
+	m.ProcessOrder(orderbook.NewOrder("best", "u", deltaSymbol, orderbook.Buy, orderbook.Limit, 101, 1))
+	m.ProcessOrder(orderbook.NewOrder("a", "u", deltaSymbol, orderbook.Buy, orderbook.Limit, 100, 2))
+	m.ProcessOrder(orderbook.NewOrder("b", "u", deltaSymbol, orderbook.Buy, orderbook.Limit, 100, 3))
+
+	delta := m.CancelOrder(deltaSymbol, "a").OrderbookDelta
+	if fmt.Sprint(delta.Bids, delta.Asks) != "[[100 3]] []" {
+		t.Fatalf("got bids %v asks %v, want [[100 3]] []", delta.Bids, delta.Asks)
+	}
+}
@@ -0,0 +1,171 @@
+package matcher
+
+import "github.com/opencode-exchange/matching-engine/internal/orderbook"
+
+// Reference prices a price band can be centred on.
+const (
+	BandLastTrade = "LAST_TRADE"
+	BandMid       = "MID"
+)
+
+// MarketRules are the trading rules of a symbol in ticks and lots of its
+// Scale. A zero limit is not enforced.
+type MarketRules struct {
+	Symbol      string
+	Scale       orderbook.Scale
+	TickSize    int64
+	StepSize    int64
+	MinQty      int64
+	MaxQty      int64
+	MinNotional orderbook.Quote
+
+	// PriceBandBps keeps takers from trading further than that many basis
+	// points from PriceBandReference.
+	PriceBandBps       int64
+	PriceBandReference string
+
+	// STP is the self-trade prevention mode of orders that do not choose
+	// one. STPDefault means STPNone.
+	STP orderbook.SelfTradePrevention
+}
+
+// check returns the reason order breaks the rules, or "" if it does not.
+func (r *MarketRules) check(order *orderbook.Order) string {
+	if order.IsQuoteOrder() {
+		switch {
+		case order.Type != orderbook.Market || order.Side != orderbook.Buy || order.TimeInForce == orderbook.FOK:
+			return ReasonInvalidOrder
+		case order.Quantity != 0:
+			return ReasonInvalidQuantity
+		case order.QuoteQty.Cmp(r.MinNotional) < 0:
+			return ReasonMinNotional
+		}
+		return ""
+	}
+
+	if order.Type.IsConditional() {
+		if order.StopPrice <= 0 {
+			return ReasonInvalidStopPrice
+		}
+		if r.TickSize > 0 && order.StopPrice%r.TickSize != 0 {
+			return ReasonTickSize
+		}
+	}
+
+	hasPrice := order.Type.Triggered() == orderbook.Limit
+	if hasPrice {
+		if order.Price <= 0 {
+			return ReasonInvalidPrice
+		}
+		if r.TickSize > 0 && order.Price%r.TickSize != 0 {
+			return ReasonTickSize
+		}
+	}
+
+	switch {
+	case order.Quantity <= 0:
+		return ReasonInvalidQuantity
+	case r.StepSize > 0 && order.Quantity%r.StepSize != 0:
+		return ReasonStepSize
+	case r.MinQty > 0 && order.Quantity < r.MinQty:
+		return ReasonMinQty
+	case r.MaxQty > 0 && order.Quantity > r.MaxQty:
+		return ReasonMaxQty
+	}
+
+	if order.IsIceberg() {
+		switch {
+		case !hasPrice:
+			return ReasonInvalidOrder
+		case order.DisplayQty > order.Quantity:
+			return ReasonInvalidQuantity
+		case r.StepSize > 0 && order.DisplayQty%r.StepSize != 0:
+			return ReasonStepSize
+		case r.MinQty > 0 && order.DisplayQty < r.MinQty:
+			return ReasonMinQty
+		}
+	}
+
+	// The notional of a market order is not known until it matches.
+	if hasPrice && !r.MinNotional.IsZero() &&
+		orderbook.MulQuote(order.Price, order.Quantity).Cmp(r.MinNotional) < 0 {
+		return ReasonMinNotional
+	}
+
+	return ""
+}
+
+// affordableQty returns how much of what is left of a quote order's budget
+// buys at price, rounded down to the step size and kept within MaxQty.
+func (r *MarketRules) affordableQty(order *orderbook.Order, price int64) int64 {
+	qty := order.QuoteQty.Sub(order.FilledQuote).Div(price)
+	if r.StepSize > 0 {
+		qty -= qty % r.StepSize
+	}
+	if r.MaxQty > 0 && qty > r.MaxQty-order.FilledQty {
+		qty = r.MaxQty - order.FilledQty
+	}
+	return qty
+}
+
+// priceLimit returns the furthest maker price order may trade at under the
+// market's price band and the order's own maxSlippageBps, or false if
+// neither applies.
+func (r *MarketRules) priceLimit(ob *orderbook.Orderbook, order *orderbook.Order, opposite *orderbook.BookSide) (int64, bool) {
+	buy := order.Side == orderbook.Buy
+
+	var limit int64
+	var ok bool
+	tighten := func(ref, bps int64) {
+		edge := bandEdge(ref, bps, buy)
+		if !ok || buy && edge < limit || !buy && edge > limit {
+			limit, ok = edge, true
+		}
+	}
+
+	if r.PriceBandBps > 0 {
+		if ref := r.referencePrice(ob, opposite); ref > 0 {
+			tighten(ref, r.PriceBandBps)
+		}
+	}
+	if order.MaxSlippageBps > 0 {
+		if best := opposite.Best(); best != nil {
+			tighten(best.Price, order.MaxSlippageBps)
+		}
+	}
+
+	return limit, ok
+}
+
+// referencePrice falls back from the last trade to the mid price to the best
+// opposite price, whichever is first available.
+func (r *MarketRules) referencePrice(ob *orderbook.Orderbook, opposite *orderbook.BookSide) int64 {
+	if r.PriceBandReference != BandMid && ob.LastTradePrice > 0 {
+		return ob.LastTradePrice
+	}
+
+	bid, ask := ob.Bids.Best(), ob.Asks.Best()
+	if bid != nil && ask != nil {
+		return bid.Price + (ask.Price-bid.Price)/2
+	}
+
+	if r.PriceBandReference == BandMid && ob.LastTradePrice > 0 {
+		return ob.LastTradePrice
+	}
+	if best := opposite.Best(); best != nil {
+		return best.Price
+	}
+	return 0
+}
+
+// bandEdge returns ref moved bps basis points up for a buy or down for a sell,
+// rounded towards ref.
+func bandEdge(ref, bps int64, buy bool) int64 {
+	if buy {
+		return orderbook.MulQuote(ref, 10000+bps).Div(10000)
+	}
+	if bps >= 10000 {
+		return 0
+	}
+	return orderbook.MulQuote(ref, 10000-bps).Add(orderbook.MulQuote(1, 9999)).Div(10000)
+}
@@ -0,0 +1,143 @@
+package matcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+)
+
+// TestPriceLimits trades at 100 to set the reference, then rests asks at
+// 101, 104 and 106 and bids at 99, 96 and 94, one lot each, and sends a
+// taker for 3. A taker may only trade up to the edge of the band or of its
+// own slippage limit; what it cannot trade for that is cancelled rather than
+// rested. A limit that stops at its own price rests as usual.
+func TestPriceLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		bandBps   int64
+		reference string
+		side      orderbook.Side
+		orderType orderbook.OrderType
+		price     int64
+		slippage  int64
+		status    string
+		reason    string
+		prices    string
+	}{
+		{"limit inside the band", 500, BandLastTrade, orderbook.Buy, orderbook.Limit, 104, 0, StatusPartial, "", "[101 104]"},
+		{"buy limit past the band", 500, BandLastTrade, orderbook.Buy, orderbook.Limit, 120, 0, StatusCancelled, ReasonPriceBand, "[101 104]"},
+		{"sell limit past the band", 500, BandLastTrade, orderbook.Sell, orderbook.Limit, 80, 0, StatusCancelled, ReasonPriceBand, "[99 96]"},
+		{"limit only reaching past the band", 50, BandLastTrade, orderbook.Buy, orderbook.Limit, 120, 0, StatusCancelled, ReasonPriceBand, "[]"},
+		{"band around the mid", 300, BandMid, orderbook.Buy, orderbook.Limit, 120, 0, StatusCancelled, ReasonPriceBand, "[101]"},
+		{"market sweep without limits", 0, "", orderbook.Buy, orderbook.Market, 0, 0, StatusFilled, "", "[101 104 106]"},
+		{"market sweep stopped by slippage", 0, "", orderbook.Buy, orderbook.Market, 0, 200, StatusCancelled, ReasonPriceBand, "[101]"},
+		{"market sell stopped by slippage", 0, "", orderbook.Sell, orderbook.Market, 0, 400, StatusCancelled, ReasonPriceBand, "[99 96]"},
+		{"slippage tighter than the band", 1000, BandLastTrade, orderbook.Buy, orderbook.Market, 0, 200, StatusCancelled, ReasonPriceBand, "[101]"},
+		{"band tighter than slippage", 500, BandLastTrade, orderbook.Buy, orderbook.Market, 0, 1000, StatusCancelled, ReasonPriceBand, "[101 104]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newTestMatcher(t, MarketRules{PriceBandBps: tc.bandBps, PriceBandReference: tc.reference})
+			m.ProcessOrder(limit("x1", "x", orderbook.Sell, 100, 1))
+			m.ProcessOrder(limit("x2", "y", orderbook.Buy, 100, 1))
+			for i, price := range []int64{101, 104, 106} {
+				m.ProcessOrder(limit(fmt.Sprintf("a%d", i), "m", orderbook.Sell, price, 1))
+			}
+			for i, price := range []int64{99, 96, 94} {
+				m.ProcessOrder(limit(fmt.Sprintf("b%d", i), "m", orderbook.Buy, price, 1))
+			}
+
+			taker := orderbook.NewOrder("t1", "t", testSymbol, tc.side, tc.orderType, tc.price, 3)
+			taker.MaxSlippageBps = tc.slippage
+			result := m.ProcessOrder(taker)
+
+			var prices []int64
+			for _, trade := range result.Trades {
+				prices = append(prices, trade.Price)
+			}
+			if got := fmt.Sprint(prices); got != tc.prices {
+				t.Fatalf("traded at %s, want %s", got, tc.prices)
+			}
+			if u := lastUpdate(t, result, "t1"); u.Status != tc.status || u.Reason != tc.reason {
+				t.Fatalf("taker ended %s %s, want %s %s", u.Status, u.Reason, tc.status, tc.reason)
+			}
+			if rests := m.GetOrderbook(testSymbol).GetOrder("t1") != nil; rests != (tc.status == StatusPartial) {
+				t.Fatalf("taker rests: %v", rests)
+			}
+		})
+	}
+}
+
+func TestBandEdge(t *testing.T) {
+	for _, tc := range []struct {
+		ref, bps int64
+		buy      bool
+		want     int64
+	}{
+		{100, 500, true, 105},
+		{100, 500, false, 95},
+		// Rounded towards the reference.
+		{1001, 150, true, 1016},
+		{1001, 150, false, 986},
+		{100, 10000, false, 0},
+	} {
+		if got := bandEdge(tc.ref, tc.bps, tc.buy); got != tc.want {
+			t.Errorf("bandEdge(%d, %d, %v) = %d, want %d", tc.ref, tc.bps, tc.buy, got, tc.want)
+		}
+	}
+}
+
+// TestQuoteOrders rests asks a1 and a2 at 100 and a3 at 110, ten lots each,
+// and sends a market buy for a quote amount. At each level the taker buys
+// what the rest of its budget affords, rounded down to the step size, and is
+// filled once that is nothing; the unspent remainder is not traded.
+func TestQuoteOrders(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		step   int64
+		budget uint64
+		trades string
+		spent  uint64
+		status string
+		reason string
+	}{
+		{"budget spent inside a level", 1, 1500, "[a1:10 a2:5]", 1500, StatusFilled, ""},
+		{"residual rounded down to the step", 5, 1950, "[a1:10 a2:5]", 1500, StatusFilled, ""},
+		{"residual rounded at the next level", 5, 2770, "[a1:10 a2:10 a3:5]", 2550, StatusFilled, ""},
+		{"budget below one step", 10, 999, "[]", 0, StatusCancelled, ReasonQuoteQtyTooSmall},
+		{"budget outlasting the book", 1, 10000, "[a1:10 a2:10 a3:10]", 3100, StatusCancelled, ReasonNoLiquidity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newTestMatcher(t, MarketRules{StepSize: tc.step})
+			m.ProcessOrder(limit("a1", "m", orderbook.Sell, 100, 10))
+			m.ProcessOrder(limit("a2", "m", orderbook.Sell, 100, 10))
+			m.ProcessOrder(limit("a3", "m", orderbook.Sell, 110, 10))
+
+			taker := orderbook.NewOrder("t1", "t", testSymbol, orderbook.Buy, orderbook.Market, 0, 0)
+			taker.QuoteQty = orderbook.Quote{Lo: tc.budget}
+			result := m.ProcessOrder(taker)
+
+			if got := tradeQtys(result); got != tc.trades {
+				t.Fatalf("traded %s, want %s", got, tc.trades)
+			}
+			var spent orderbook.Quote
+			for _, trade := range result.Trades {
+				if tc.step > 1 && trade.Quantity%tc.step != 0 {
+					t.Fatalf("traded %d lots, not a multiple of the step %d", trade.Quantity, tc.step)
+				}
+				spent = spent.Add(trade.QuoteQty)
+			}
+			u := lastUpdate(t, result, "t1")
+			if u.Status != tc.status || u.Reason != tc.reason {
+				t.Fatalf("taker %s/%s, want %s/%s", u.Status, u.Reason, tc.status, tc.reason)
+			}
+			want := orderbook.Quote{Lo: tc.spent}
+			if spent != want || u.FilledQuote != want {
+				t.Fatalf("spent %v in trades and %v on the taker, want %v", spent, u.FilledQuote, want)
+			}
+			if m.GetOrderbook(testSymbol).GetOrder("t1") != nil {
+				t.Fatal("quote order rested")
+			}
+		})
+	}
+}
@@ -1,98 +1,370 @@
 package matcher
 
 import (
+	"fmt"
+	"sort"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/opencode-exchange/matching-engine/internal/fees"
 	"github.com/opencode-exchange/matching-engine/internal/orderbook"
-	"github.com/shopspring/decimal"
 )
 
+// Prices and quantities in results are ticks and lots of the market's Scale.
 type Trade struct {
 	ID           string
 	Symbol       string
-	Price        decimal.Decimal
-	Quantity     decimal.Decimal
-	QuoteQty     decimal.Decimal
+	Price        int64
+	Quantity     int64
+	QuoteQty     orderbook.Quote
 	MakerOrderID string
 	TakerOrderID string
 	MakerUserID  string
 	TakerUserID  string
 	IsBuyerMaker bool
+	MakerFee     fees.Fee
+	TakerFee     fees.Fee
 	ExecutedAt   time.Time
 }
 
+const (
+	StatusNew       = "NEW"
+	StatusPartial   = "PARTIAL"
+	StatusFilled    = "FILLED"
+	StatusCancelled = "CANCELLED"
+	StatusRejected  = "REJECTED"
+)
+
+const (
+	ReasonUserCancelled     = "USER_CANCELLED"
+	ReasonNoLiquidity       = "NO_LIQUIDITY"
+	ReasonImmediateOrCancel = "IMMEDIATE_OR_CANCEL"
+	ReasonFillOrKill        = "FILL_OR_KILL"
+	ReasonPostOnly          = "POST_ONLY_WOULD_CROSS"
+	ReasonInvalidOrder      = "INVALID_ORDER"
+	ReasonSelfTrade         = "SELF_TRADE"
+	ReasonMalformedCommand  = "MALFORMED_COMMAND"
+	ReasonInvalidPrice      = "INVALID_PRICE"
+	ReasonInvalidQuantity   = "INVALID_QUANTITY"
+	ReasonUnknownSymbol     = "UNKNOWN_SYMBOL"
+	ReasonTickSize          = "TICK_SIZE"
+	ReasonStepSize          = "STEP_SIZE"
+	ReasonMinQty            = "MIN_QTY"
+	ReasonMaxQty            = "MAX_QTY"
+	ReasonMinNotional       = "MIN_NOTIONAL"
+	ReasonQuoteQtyTooSmall  = "QUOTE_QTY_TOO_SMALL"
+	ReasonPriceBand         = "PRICE_BAND"
+	ReasonWouldTrigger      = "WOULD_TRIGGER_IMMEDIATELY"
+	ReasonInvalidStopPrice  = "INVALID_STOP_PRICE"
+	ReasonAmended           = "AMENDED"
+	ReasonMassCancel        = "MASS_CANCEL"
+	ReasonHeartbeatExpired  = "HEARTBEAT_EXPIRED"
+)
+
 type OrderUpdate struct {
 	OrderID      string
-	RemainingQty decimal.Decimal
+	UserID       string
+	Symbol       string
 	Status       string
+	FilledQty    int64
+	RemainingQty int64
+	FilledQuote  orderbook.Quote
+	Reason       string
+	UpdatedAt    time.Time
+}
+
+func newOrderUpdate(order *orderbook.Order, status, reason string) *OrderUpdate {
+	return &OrderUpdate{
+		OrderID:      order.ID,
+		UserID:       order.UserID,
+		Symbol:       order.Symbol,
+		Status:       status,
+		FilledQty:    order.FilledQty,
+		RemainingQty: order.RemainingQty,
+		FilledQuote:  order.FilledQuote,
+		Reason:       reason,
+	}
 }
 
 type MatchResult struct {
-	Trades       []*Trade
-	OrderUpdates []*OrderUpdate
+	Symbol         string
+	Trades         []*Trade
+	OrderUpdates   []*OrderUpdate
 	OrderbookDelta *OrderbookDelta
 }
 
+// OrderbookDelta is numbered by its book: Sequence is PrevSequence plus one
+// and PrevSequence is the Sequence of the book's previous delta.
 type OrderbookDelta struct {
-	Symbol    string
-	Sequence  uint64
-	Bids      [][2]string
-	Asks      [][2]string
-	Timestamp int64
+	Symbol       string
+	PrevSequence uint64
+	Sequence     uint64
+	Bids         [][2]int64
+	Asks         [][2]int64
+	Timestamp    int64
 }
 
+// Matcher may handle commands for different symbols concurrently, as long as
+// each symbol is only handled by one goroutine at a time, its writer.
+// Commands that span books, such as CancelAll without a symbol, and setup
+// methods need exclusive use. The set of books is fixed once markets are
+// added.
+//
+// Readers never touch a book. View may be called from any goroutine at any
+// time and returns the book as the last command on it left it.
 type Matcher struct {
 	orderbooks map[string]*orderbook.Orderbook
+	markets    map[string]*MarketRules
+	fees       *fees.Schedule
+	clock      Clock
+	ids        IDGenerator
+
+	// books is a copy of orderbooks for readers, replaced whenever a book is
+	// added.
+	books atomic.Pointer[map[string]*orderbook.Orderbook]
 }
 
-func NewMatcher() *Matcher {
+// NewMatcher returns a matcher that takes the time from clock and names
+// trades with ids. A nil clock or ids is replaced by a CommandClock or
+// SequentialIDs, so that the same commands always give the same output.
+func NewMatcher(clock Clock, ids IDGenerator) *Matcher {
+	if clock == nil {
+		clock = NewCommandClock()
+	}
+	if ids == nil {
+		ids = NewSequentialIDs()
+	}
 	return &Matcher{
 		orderbooks: make(map[string]*orderbook.Orderbook),
+		markets:    make(map[string]*MarketRules),
+		fees:       fees.NewSchedule(),
+		clock:      clock,
+		ids:        ids,
+	}
+}
+
+// AddMarket registers a symbol and its rules and creates its book. Orders
+// for symbols that were never added are rejected.
+func (m *Matcher) AddMarket(rules MarketRules) {
+	m.markets[rules.Symbol] = &rules
+	m.GetOrCreateOrderbook(rules.Symbol)
+}
+
+func (m *Matcher) Scale(symbol string) orderbook.Scale {
+	if mkt, ok := m.markets[symbol]; ok {
+		return mkt.Scale
 	}
+	return orderbook.DefaultScale
+}
+
+func (m *Matcher) SetFeeSchedule(schedule *fees.Schedule) {
+	m.fees = schedule
+}
+
+// stamp sets the time on everything result reports. A command takes no
+// time, so its trades, execution reports and delta share one timestamp.
+func (m *Matcher) stamp(result *MatchResult) *MatchResult {
+	now := m.clock.Now(result.Symbol)
+	for _, trade := range result.Trades {
+		trade.ExecutedAt = now
+	}
+	for _, update := range result.OrderUpdates {
+		update.UpdatedAt = now
+	}
+	if result.OrderbookDelta != nil {
+		result.OrderbookDelta.Timestamp = now.UnixMilli()
+	}
+	return result
+}
+
+func (m *Matcher) selfTradePrevention(order *orderbook.Order) orderbook.SelfTradePrevention {
+	mode := order.STP
+	if mode == orderbook.STPDefault {
+		if mkt, ok := m.markets[order.Symbol]; ok {
+			mode = mkt.STP
+		}
+	}
+	if mode == orderbook.STPDefault {
+		mode = orderbook.STPNone
+	}
+	return mode
+}
+
+func (m *Matcher) chargeFees(scale orderbook.Scale, trade *Trade) {
+	qty := scale.Qty(trade.Quantity)
+	quoteQty := scale.Quote(trade.QuoteQty)
+	trade.MakerFee = m.fees.Charge(trade.Symbol, trade.MakerUserID, true, trade.IsBuyerMaker, qty, quoteQty)
+	trade.TakerFee = m.fees.Charge(trade.Symbol, trade.TakerUserID, false, !trade.IsBuyerMaker, qty, quoteQty)
 }
 
 func (m *Matcher) GetOrCreateOrderbook(symbol string) *orderbook.Orderbook {
 	ob, exists := m.orderbooks[symbol]
 	if !exists {
-		ob = orderbook.NewOrderbook(symbol)
+		ob = orderbook.NewOrderbook(symbol, m.Scale(symbol))
 		m.orderbooks[symbol] = ob
+		m.publishBooks()
 	}
 	return ob
 }
 
+func (m *Matcher) publishBooks() {
+	books := make(map[string]*orderbook.Orderbook, len(m.orderbooks))
+	for symbol, ob := range m.orderbooks {
+		books[symbol] = ob
+	}
+	m.books.Store(&books)
+}
+
+// View returns the last published state of symbol's book, or nil if there
+// is no such book.
+func (m *Matcher) View(symbol string) *orderbook.View {
+	books := m.books.Load()
+	if books == nil {
+		return nil
+	}
+	if ob, exists := (*books)[symbol]; exists {
+		return ob.View()
+	}
+	return nil
+}
+
+// Views returns the last published state of every book, by symbol.
+func (m *Matcher) Views() []*orderbook.View {
+	books := m.books.Load()
+	if books == nil {
+		return nil
+	}
+	views := make([]*orderbook.View, 0, len(*books))
+	for _, ob := range *books {
+		views = append(views, ob.View())
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Symbol < views[j].Symbol })
+	return views
+}
+
 func (m *Matcher) ProcessOrder(order *orderbook.Order) *MatchResult {
-	ob := m.GetOrCreateOrderbook(order.Symbol)
+	order.Timestamp = m.clock.Now(order.Symbol)
+	return m.stamp(m.processOrder(order))
+}
+
+func (m *Matcher) processOrder(order *orderbook.Order) *MatchResult {
 	result := &MatchResult{
+		Symbol:       order.Symbol,
 		Trades:       make([]*Trade, 0),
 		OrderUpdates: make([]*OrderUpdate, 0),
 	}
 
-	if order.Type == orderbook.Market && order.Side == orderbook.Buy {
-		order.Price = decimal.NewFromInt(999999999)
-	} else if order.Type == orderbook.Market && order.Side == orderbook.Sell {
-		order.Price = decimal.Zero
+	mkt, ok := m.markets[order.Symbol]
+	if !ok {
+		return rejectOrder(result, order, StatusRejected, ReasonUnknownSymbol)
 	}
+	if reason := mkt.check(order); reason != "" {
+		return rejectOrder(result, order, StatusRejected, reason)
+	}
+
+	ob := m.GetOrCreateOrderbook(order.Symbol)
+	touched := newTouchedLevels()
+
+	if order.Type.IsConditional() {
+		if order.StopReached(ob.LastTradePrice) {
+			return rejectOrder(result, order, StatusRejected, ReasonWouldTrigger)
+		}
+		ob.Triggers.Add(order)
+		ob.Publish()
+		result.OrderUpdates = append(result.OrderUpdates, newOrderUpdate(order, StatusNew, ""))
+		return result
+	}
+
+	m.match(ob, mkt, order, result, touched)
+	m.fireTriggers(ob, mkt, result, touched)
+
+	result.OrderbookDelta = touched.delta(ob)
+	ob.Publish()
+	return result
+}
+
+// fireTriggers matches conditional orders whose stop price the last trade
+// has reached, one at a time so that their own trades can trigger more.
+func (m *Matcher) fireTriggers(ob *orderbook.Orderbook, mkt *MarketRules, result *MatchResult, touched *touchedLevels) {
+	for {
+		order := ob.Triggers.Next(ob.LastTradePrice)
+		if order == nil {
+			return
+		}
+		order.Type = order.Type.Triggered()
+		m.match(ob, mkt, order, result, touched)
+	}
+}
 
+// match runs order against the book and reports its outcome.
+func (m *Matcher) match(ob *orderbook.Orderbook, mkt *MarketRules, order *orderbook.Order, result *MatchResult, touched *touchedLevels) {
 	var oppositeSide *orderbook.BookSide
-	var priceMatches func(makerPrice, takerPrice decimal.Decimal) bool
+	var priceMatches func(makerPrice, takerPrice int64) bool
 
-	if order.Side == orderbook.Buy {
+	switch {
+	case order.Type == orderbook.Market:
 		oppositeSide = ob.Asks
-		priceMatches = func(makerPrice, takerPrice decimal.Decimal) bool {
-			return makerPrice.LessThanOrEqual(takerPrice)
+		if order.Side == orderbook.Sell {
+			oppositeSide = ob.Bids
 		}
-	} else {
+		priceMatches = func(makerPrice, takerPrice int64) bool {
+			return true
+		}
+	case order.Side == orderbook.Buy:
+		oppositeSide = ob.Asks
+		priceMatches = func(makerPrice, takerPrice int64) bool {
+			return makerPrice <= takerPrice
+		}
+	default:
 		oppositeSide = ob.Bids
-		priceMatches = func(makerPrice, takerPrice decimal.Decimal) bool {
-			return makerPrice.GreaterThanOrEqual(takerPrice)
+		priceMatches = func(makerPrice, takerPrice int64) bool {
+			return makerPrice >= takerPrice
 		}
 	}
 
-	bidDeltas := make(map[string]decimal.Decimal)
-	askDeltas := make(map[string]decimal.Decimal)
+	// Makers past the price band are out of reach even if the order's own
+	// price would take them.
+	inBand := func(makerPrice int64) bool { return true }
+	if limit, ok := mkt.priceLimit(ob, order, oppositeSide); ok {
+		inBand = func(makerPrice int64) bool {
+			if order.Side == orderbook.Buy {
+				return makerPrice <= limit
+			}
+			return makerPrice >= limit
+		}
+	}
 
-	for !order.IsFilled() {
+	switch order.TimeInForce {
+	case orderbook.PostOnly:
+		if order.Type == orderbook.Market {
+			rejectOrder(result, order, StatusRejected, ReasonInvalidOrder)
+			return
+		}
+		if best := oppositeSide.Best(); best != nil && priceMatches(best.Price, order.Price) {
+			rejectOrder(result, order, StatusRejected, ReasonPostOnly)
+			return
+		}
+	case orderbook.FOK:
+		fillable := func(makerPrice, takerPrice int64) bool {
+			return inBand(makerPrice) && priceMatches(makerPrice, takerPrice)
+		}
+		if !canFill(oppositeSide, order, fillable, m.selfTradePrevention(order)) {
+			rejectOrder(result, order, StatusCancelled, ReasonFillOrKill)
+			return
+		}
+	}
+
+	makerSide := orderbook.Sell
+	if order.Side == orderbook.Sell {
+		makerSide = orderbook.Buy
+	}
+
+	stp := m.selfTradePrevention(order)
+	selfTradeCancelled := false
+	quoteSpent := false
+	bandHit := false
+
+	for !selfTradeCancelled {
 		bestLevel := oppositeSide.Best()
 		if bestLevel == nil {
 			break
@@ -101,6 +373,18 @@ func (m *Matcher) ProcessOrder(order *orderbook.Order) *MatchResult {
 		if !priceMatches(bestLevel.Price, order.Price) {
 			break
 		}
+		if order.IsQuoteOrder() {
+			order.RemainingQty = mkt.affordableQty(order, bestLevel.Price)
+			quoteSpent = order.RemainingQty == 0
+		}
+		if order.IsFilled() {
+			break
+		}
+
+		if !inBand(bestLevel.Price) {
+			bandHit = true
+			break
+		}
 
 		for !order.IsFilled() && !bestLevel.IsEmpty() {
 			makerOrder := bestLevel.Front()
@@ -108,16 +392,22 @@ func (m *Matcher) ProcessOrder(order *orderbook.Order) *MatchResult {
 				break
 			}
 
-			tradeQty := order.RemainingQty
-			if makerOrder.RemainingQty.LessThan(tradeQty) {
-				tradeQty = makerOrder.RemainingQty
+			if stp != orderbook.STPNone && makerOrder.UserID == order.UserID {
+				touched.add(makerSide, makerOrder.Price)
+				selfTradeCancelled = preventSelfTrade(ob, bestLevel, order, makerOrder, stp, result)
+				if selfTradeCancelled {
+					break
+				}
+				continue
 			}
 
+			tradeQty := min(order.RemainingQty, makerOrder.Visible())
+
 			tradePrice := makerOrder.Price
-			quoteQty := tradePrice.Mul(tradeQty)
+			quoteQty := orderbook.MulQuote(tradePrice, tradeQty)
 
 			trade := &Trade{
-				ID:           uuid.New().String(),
+				ID:           m.ids.TradeID(order.Symbol),
 				Symbol:       order.Symbol,
 				Price:        tradePrice,
 				Quantity:     tradeQty,
@@ -127,126 +417,402 @@ func (m *Matcher) ProcessOrder(order *orderbook.Order) *MatchResult {
 				MakerUserID:  makerOrder.UserID,
 				TakerUserID:  order.UserID,
 				IsBuyerMaker: makerOrder.Side == orderbook.Buy,
-				ExecutedAt:   time.Now(),
 			}
+			m.chargeFees(ob.Scale, trade)
 			result.Trades = append(result.Trades, trade)
 
-			makerOrder.Fill(tradeQty)
-			order.Fill(tradeQty)
+			makerOrder.Fill(tradeQty, tradePrice)
+			order.Fill(tradeQty, tradePrice)
+			ob.LastTradePrice = tradePrice
+
+			bestLevel.UpdateVolume(-tradeQty)
 
-			makerStatus := "PARTIAL"
+			makerStatus := StatusPartial
 			if makerOrder.IsFilled() {
-				makerStatus = "FILLED"
+				makerStatus = StatusFilled
 				ob.RemoveOrder(makerOrder.ID)
-			} else {
-				bestLevel.UpdateVolume(tradeQty.Neg())
+			} else if makerOrder.Visible() == 0 {
+				ob.Replenish(makerOrder)
 			}
 
-			result.OrderUpdates = append(result.OrderUpdates, &OrderUpdate{
-				OrderID:      makerOrder.ID,
-				RemainingQty: makerOrder.RemainingQty,
-				Status:       makerStatus,
-			})
+			result.OrderUpdates = append(result.OrderUpdates, newOrderUpdate(makerOrder, makerStatus, ""))
 
-			if order.Side == orderbook.Buy {
-				askDeltas[tradePrice.String()] = askDeltas[tradePrice.String()].Sub(tradeQty)
-			} else {
-				bidDeltas[tradePrice.String()] = bidDeltas[tradePrice.String()].Sub(tradeQty)
-			}
+			touched.add(makerSide, tradePrice)
 		}
 	}
 
-	takerStatus := "FILLED"
-	if !order.IsFilled() {
-		if order.Type == orderbook.Limit {
+	takerStatus, takerReason := StatusFilled, ""
+	if selfTradeCancelled {
+		takerStatus, takerReason = StatusCancelled, ReasonSelfTrade
+	} else if bandHit {
+		// Whatever is left would trade through the band, so it is not
+		// allowed to rest either.
+		takerStatus, takerReason = StatusCancelled, ReasonPriceBand
+	} else if order.IsQuoteOrder() {
+		// A quote order is filled once the rest of its budget cannot buy a
+		// single step at the best price.
+		if !quoteSpent {
+			takerStatus, takerReason = StatusCancelled, ReasonNoLiquidity
+		} else if order.FilledQty == 0 {
+			takerStatus, takerReason = StatusCancelled, ReasonQuoteQtyTooSmall
+		}
+	} else if !order.IsFilled() {
+		switch {
+		case order.Type != orderbook.Limit:
+			// The unfilled remainder of a market order never rests; FilledQty
+			// tells a partial execution apart from one that never traded.
+			takerStatus, takerReason = StatusCancelled, ReasonNoLiquidity
+		case order.TimeInForce == orderbook.IOC:
+			takerStatus, takerReason = StatusCancelled, ReasonImmediateOrCancel
+		case order.TimeInForce == orderbook.FOK:
+			// canFill already turns away a FOK that cannot fill; this only
+			// keeps one that ran short from resting.
+			takerStatus, takerReason = StatusCancelled, ReasonFillOrKill
+		default:
+			order.Refresh()
 			ob.AddOrder(order)
-			takerStatus = "NEW"
-			if len(result.Trades) > 0 {
-				takerStatus = "PARTIAL"
-			}
-
-			if order.Side == orderbook.Buy {
-				bidDeltas[order.Price.String()] = bidDeltas[order.Price.String()].Add(order.RemainingQty)
-			} else {
-				askDeltas[order.Price.String()] = askDeltas[order.Price.String()].Add(order.RemainingQty)
-			}
-		} else {
-			if len(result.Trades) > 0 {
-				takerStatus = "PARTIAL"
-			} else {
-				takerStatus = "CANCELLED"
-			}
+			takerStatus = restingStatus(order)
+			touched.add(order.Side, order.Price)
 		}
 	}
 
-	result.OrderUpdates = append(result.OrderUpdates, &OrderUpdate{
-		OrderID:      order.ID,
-		RemainingQty: order.RemainingQty,
-		Status:       takerStatus,
-	})
+	if order.IsQuoteOrder() {
+		order.RemainingQty = 0
+	}
+	result.OrderUpdates = append(result.OrderUpdates, newOrderUpdate(order, takerStatus, takerReason))
+}
 
-	bids := make([][2]string, 0, len(bidDeltas))
-	for price := range bidDeltas {
-		if level := ob.Bids.GetLevel(price); level != nil {
-			bids = append(bids, [2]string{price, level.Volume.String()})
-		} else {
-			bids = append(bids, [2]string{price, "0"})
-		}
+// preventSelfTrade applies mode to a taker that would trade against its own
+// resting order and reports whether the taker has been cancelled.
+func preventSelfTrade(ob *orderbook.Orderbook, level *orderbook.PriceLevel, taker, maker *orderbook.Order, mode orderbook.SelfTradePrevention, result *MatchResult) bool {
+	cancelMaker := func() {
+		ob.RemoveOrder(maker.ID)
+		result.OrderUpdates = append(result.OrderUpdates, newOrderUpdate(maker, StatusCancelled, ReasonSelfTrade))
 	}
 
-	asks := make([][2]string, 0, len(askDeltas))
-	for price := range askDeltas {
-		if level := ob.Asks.GetLevel(price); level != nil {
-			asks = append(asks, [2]string{price, level.Volume.String()})
+	switch mode {
+	case orderbook.STPCancelOldest:
+		cancelMaker()
+		return false
+	case orderbook.STPCancelBoth:
+		cancelMaker()
+		return true
+	case orderbook.STPDecrementAndCancel:
+		qty := min(taker.RemainingQty, maker.Visible())
+		taker.Reduce(qty)
+		if taker.IsQuoteOrder() {
+			taker.QuoteQty = taker.QuoteQty.Sub(orderbook.MulQuote(maker.Price, qty))
+		}
+		if maker.RemainingQty == qty {
+			cancelMaker()
 		} else {
-			asks = append(asks, [2]string{price, "0"})
+			level.UpdateVolume(-qty)
+			maker.Reduce(qty)
+			if maker.Visible() == 0 {
+				ob.Replenish(maker)
+			}
+			result.OrderUpdates = append(result.OrderUpdates, newOrderUpdate(maker, restingStatus(maker), ReasonSelfTrade))
 		}
+		return taker.RemainingQty == 0
+	default:
+		return true
 	}
+}
 
-	result.OrderbookDelta = &OrderbookDelta{
-		Symbol:    order.Symbol,
-		Sequence:  ob.GetSequence(),
-		Bids:      bids,
-		Asks:      asks,
-		Timestamp: time.Now().UnixMilli(),
+func restingStatus(order *orderbook.Order) string {
+	if order.FilledQty == 0 {
+		return StatusNew
 	}
+	return StatusPartial
+}
+
+// canFill reports whether the opposite side holds enough volume at prices
+// the order accepts to fill it completely. Icebergs count with their hidden
+// reserve, which level volumes leave out. Under self-trade prevention the
+// order's own resting orders are no liquidity. Only cancel-oldest steps past
+// them; every other mode cancels or decrements the taker on reaching one, so
+// reaching one before the order is filled means it cannot fill.
+func canFill(oppositeSide *orderbook.BookSide, order *orderbook.Order, priceMatches func(makerPrice, takerPrice int64) bool, stp orderbook.SelfTradePrevention) bool {
+	var available int64
+	blocked := false
+	oppositeSide.Walk(func(level *orderbook.PriceLevel) bool {
+		if !priceMatches(level.Price, order.Price) {
+			return false
+		}
+		for e := level.Orders.Front(); e != nil && available < order.RemainingQty; e = e.Next() {
+			maker := e.Value.(*orderbook.Order)
+			if stp != orderbook.STPNone && maker.UserID == order.UserID {
+				if stp != orderbook.STPCancelOldest {
+					blocked = true
+					return false
+				}
+				continue
+			}
+			available += maker.RemainingQty
+		}
+		return available < order.RemainingQty
+	})
+	return !blocked && available >= order.RemainingQty
+}
 
+// rejectOrder reports an order that is turned away before touching the book.
+func rejectOrder(result *MatchResult, order *orderbook.Order, status, reason string) *MatchResult {
+	result.OrderUpdates = append(result.OrderUpdates, newOrderUpdate(order, status, reason))
 	return result
 }
 
-func (m *Matcher) CancelOrder(symbol, orderID string) (*orderbook.Order, *OrderbookDelta) {
+// RejectOrder reports a new order that was refused before it could be built,
+// such as one priced finer than its market's tick size. It touches no book.
+func (m *Matcher) RejectOrder(orderID, userID, symbol, reason string) *MatchResult {
+	order := &orderbook.Order{ID: orderID, UserID: userID, Symbol: symbol}
+	return m.stamp(rejectOrder(&MatchResult{
+		Symbol:       symbol,
+		Trades:       make([]*Trade, 0),
+		OrderUpdates: make([]*OrderUpdate, 0),
+	}, order, StatusRejected, reason))
+}
+
+// CancelOrder removes a resting order and returns its execution report and
+// book delta, or nil if the order is not in the book.
+func (m *Matcher) CancelOrder(symbol, orderID string) *MatchResult {
 	ob, exists := m.orderbooks[symbol]
 	if !exists {
-		return nil, nil
+		return nil
 	}
 
 	order := ob.RemoveOrder(orderID)
 	if order == nil {
-		return nil, nil
+		// A conditional order waiting for its trigger is not on the book, so
+		// there is no delta.
+		if order = ob.Triggers.Remove(orderID); order != nil {
+			ob.Publish()
+			return m.stamp(&MatchResult{
+				Symbol:       symbol,
+				Trades:       make([]*Trade, 0),
+				OrderUpdates: []*OrderUpdate{newOrderUpdate(order, StatusCancelled, ReasonUserCancelled)},
+			})
+		}
+		return nil
+	}
+
+	touched := newTouchedLevels()
+	touched.add(order.Side, order.Price)
+
+	result := &MatchResult{
+		Symbol:         symbol,
+		Trades:         make([]*Trade, 0),
+		OrderUpdates:   []*OrderUpdate{newOrderUpdate(order, StatusCancelled, ReasonUserCancelled)},
+		OrderbookDelta: touched.delta(ob),
+	}
+	ob.Publish()
+	return m.stamp(result)
+}
+
+// AmendOrder changes a resting order's price and total quantity; a zero
+// value keeps the current one. A smaller quantity at the same price keeps the
+// order's place in its level. A new price or a larger quantity sends it to
+// the back, matching first if the new price crosses the book. An amend that
+// breaks the market's rules leaves the order as it was and reports why. It
+// returns nil if the order is not in the book.
+func (m *Matcher) AmendOrder(symbol, orderID string, price, quantity int64) *MatchResult {
+	if result := m.amendOrder(symbol, orderID, price, quantity); result != nil {
+		return m.stamp(result)
+	}
+	return nil
+}
+
+func (m *Matcher) amendOrder(symbol, orderID string, price, quantity int64) *MatchResult {
+	ob, exists := m.orderbooks[symbol]
+	if !exists {
+		return nil
+	}
+	order := ob.GetOrder(orderID)
+	if order == nil {
+		return nil
+	}
+
+	result := &MatchResult{
+		Symbol:       symbol,
+		Trades:       make([]*Trade, 0),
+		OrderUpdates: make([]*OrderUpdate, 0),
+	}
+
+	if price == 0 {
+		price = order.Price
+	}
+	if quantity == 0 {
+		quantity = order.Quantity
 	}
 
-	var bids, asks [][2]string
-	if order.Side == orderbook.Buy {
-		bids = [][2]string{{order.Price.String(), "0"}}
-		if level := ob.Bids.Best(); level != nil && level.Price.Equal(order.Price) {
-			bids = [][2]string{{order.Price.String(), level.Volume.String()}}
+	mkt, ok := m.markets[symbol]
+	if !ok {
+		return rejectOrder(result, order, restingStatus(order), ReasonUnknownSymbol)
+	}
+
+	amended := *order
+	amended.Price = price
+	amended.Quantity = quantity
+	amended.RemainingQty = quantity - order.FilledQty
+	reason := ReasonInvalidQuantity
+	if amended.RemainingQty > 0 {
+		reason = mkt.check(&amended)
+	}
+	if reason == "" && order.TimeInForce == orderbook.PostOnly && price != order.Price {
+		opposite, crosses := ob.Asks, func(best int64) bool { return best <= price }
+		if order.Side == orderbook.Sell {
+			opposite, crosses = ob.Bids, func(best int64) bool { return best >= price }
 		}
+		if best := opposite.Best(); best != nil && crosses(best.Price) {
+			reason = ReasonPostOnly
+		}
+	}
+	if reason != "" {
+		return rejectOrder(result, order, restingStatus(order), reason)
+	}
+
+	touched := newTouchedLevels()
+	touched.add(order.Side, order.Price)
+
+	if price == order.Price && quantity <= order.Quantity {
+		ob.ReduceOrder(order, quantity)
+		result.OrderUpdates = append(result.OrderUpdates, newOrderUpdate(order, restingStatus(order), ReasonAmended))
 	} else {
-		asks = [][2]string{{order.Price.String(), "0"}}
-		if level := ob.Asks.Best(); level != nil && level.Price.Equal(order.Price) {
-			asks = [][2]string{{order.Price.String(), level.Volume.String()}}
+		ob.RemoveOrder(order.ID)
+		order.Price = price
+		order.Quantity = quantity
+		order.RemainingQty = amended.RemainingQty
+
+		m.match(ob, mkt, order, result, touched)
+		if u := result.OrderUpdates[len(result.OrderUpdates)-1]; u.Reason == "" && u.Status != StatusFilled {
+			u.Reason = ReasonAmended
 		}
+		m.fireTriggers(ob, mkt, result, touched)
 	}
 
-	return order, &OrderbookDelta{
-		Symbol:    symbol,
-		Sequence:  ob.GetSequence(),
-		Bids:      bids,
-		Asks:      asks,
-		Timestamp: time.Now().UnixMilli(),
+	result.OrderbookDelta = touched.delta(ob)
+	ob.Publish()
+	return result
+}
+
+// CancelFilter selects the orders CancelAll cancels. Empty fields match
+// every order.
+type CancelFilter struct {
+	UserID string
+	Symbol string
+	Side   *orderbook.Side
+}
+
+func (f CancelFilter) matches(order *orderbook.Order) bool {
+	return (f.UserID == "" || order.UserID == f.UserID) && (f.Side == nil || order.Side == *f.Side)
+}
+
+// CancelAll cancels every resting and untriggered order the filter selects
+// for reason. It returns one result per book it changed, each with an
+// execution report per cancelled order and a single delta.
+func (m *Matcher) CancelAll(filter CancelFilter, reason string) []*MatchResult {
+	symbols := make([]string, 0, len(m.orderbooks))
+	if filter.Symbol != "" {
+		if _, exists := m.orderbooks[filter.Symbol]; exists {
+			symbols = append(symbols, filter.Symbol)
+		}
+	} else {
+		for symbol := range m.orderbooks {
+			symbols = append(symbols, symbol)
+		}
+		sort.Strings(symbols)
 	}
+
+	results := make([]*MatchResult, 0, len(symbols))
+	for _, symbol := range symbols {
+		ob := m.orderbooks[symbol]
+
+		var resting []*orderbook.Order
+		collect := func(order *orderbook.Order) bool {
+			if filter.matches(order) {
+				resting = append(resting, order)
+			}
+			return true
+		}
+		if filter.UserID != "" {
+			for _, order := range ob.UserOrders(filter.UserID) {
+				collect(order)
+			}
+		} else {
+			ob.Bids.WalkOrders(collect)
+			ob.Asks.WalkOrders(collect)
+		}
+
+		var waiting []*orderbook.Order
+		ob.Triggers.Walk(func(order *orderbook.Order) bool {
+			if filter.matches(order) {
+				waiting = append(waiting, order)
+			}
+			return true
+		})
+
+		if len(resting) == 0 && len(waiting) == 0 {
+			continue
+		}
+
+		result := &MatchResult{
+			Symbol:       symbol,
+			Trades:       make([]*Trade, 0),
+			OrderUpdates: make([]*OrderUpdate, 0, len(resting)+len(waiting)),
+		}
+		touched := newTouchedLevels()
+		for _, order := range resting {
+			ob.RemoveOrder(order.ID)
+			touched.add(order.Side, order.Price)
+			result.OrderUpdates = append(result.OrderUpdates, newOrderUpdate(order, StatusCancelled, reason))
+		}
+		for _, order := range waiting {
+			ob.Triggers.Remove(order.ID)
+			result.OrderUpdates = append(result.OrderUpdates, newOrderUpdate(order, StatusCancelled, reason))
+		}
+		if len(resting) > 0 {
+			result.OrderbookDelta = touched.delta(ob)
+		}
+		ob.Publish()
+		results = append(results, m.stamp(result))
+	}
+	return results
 }
 
 func (m *Matcher) GetOrderbook(symbol string) *orderbook.Orderbook {
 	return m.orderbooks[symbol]
 }
+
+func (m *Matcher) Snapshot() []*orderbook.BookSnapshot {
+	symbols := make([]string, 0, len(m.orderbooks))
+	for symbol := range m.orderbooks {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	books := make([]*orderbook.BookSnapshot, len(symbols))
+	for i, symbol := range symbols {
+		books[i] = m.orderbooks[symbol].Snapshot()
+	}
+	return books
+}
+
+// Restore replaces every book with the given snapshots. It fails if a book
+// was written with a different scale than its market is configured with now,
+// since its ticks and lots would be misread.
+func (m *Matcher) Restore(books []*orderbook.BookSnapshot) error {
+	for _, snap := range books {
+		if scale := m.Scale(snap.Symbol); scale != snap.Scale {
+			return fmt.Errorf("%s: snapshot scale %+v does not match configured %+v", snap.Symbol, snap.Scale, scale)
+		}
+	}
+
+	m.orderbooks = make(map[string]*orderbook.Orderbook, len(books))
+	for _, snap := range books {
+		m.orderbooks[snap.Symbol] = orderbook.RestoreOrderbook(snap)
+	}
+	for symbol := range m.markets {
+		m.GetOrCreateOrderbook(symbol)
+	}
+	m.publishBooks()
+	return nil
+}
@@ -0,0 +1,55 @@
+package matcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+)
+
+const benchSymbol = "BTC/USDT"
+
+func newBenchMatcher() *Matcher {
+	m := NewMatcher(nil, nil)
+	m.AddMarket(MarketRules{Symbol: benchSymbol, Scale: orderbook.DefaultScale})
+	return m
+}
+
+func BenchmarkProcessOrderSingleFill(b *testing.B) {
+	m := newBenchMatcher()
+	m.ProcessOrder(orderbook.NewOrder("maker", "maker", benchSymbol, orderbook.Sell, orderbook.Limit, 10000, int64(b.N)+1))
+
+	takers := make([]*orderbook.Order, b.N)
+	for i := range takers {
+		takers[i] = orderbook.NewOrder(fmt.Sprintf("t%d", i), "taker", benchSymbol, orderbook.Buy, orderbook.Limit, 10000, 1)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.ProcessOrder(takers[i])
+	}
+}
+
+func BenchmarkProcessOrderSweep10(b *testing.B) {
+	m := newBenchMatcher()
+
+	makers := make([][]*orderbook.Order, b.N)
+	takers := make([]*orderbook.Order, b.N)
+	for i := range takers {
+		makers[i] = make([]*orderbook.Order, 10)
+		for j := range makers[i] {
+			makers[i][j] = orderbook.NewOrder(fmt.Sprintf("m%d-%d", i, j), "maker", benchSymbol, orderbook.Sell, orderbook.Limit, int64(10000+j), 1)
+		}
+		takers[i] = orderbook.NewOrder(fmt.Sprintf("t%d", i), "taker", benchSymbol, orderbook.Buy, orderbook.Limit, 10009, 10)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, maker := range makers[i] {
+			m.ProcessOrder(maker)
+		}
+		m.ProcessOrder(takers[i])
+	}
+}
@@ -0,0 +1,560 @@
+package matcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+)
+
+const stpSymbol = "BTC/USDT"
+
+// TestFillOrKillWithSelfTradePrevention places a FOK buy for 8 by user t
+// against asks of 5 by m and 3 by t at 100, then extra by m at 101. With too
+// little liquidity from other users, every mode but none kills the order
+// without touching the book. With enough, only cancel-oldest, which removes
+// t's ask, may fill it; the other modes would stop at that ask, so they kill
+// it too. It never rests or fills partly.
+func TestFillOrKillWithSelfTradePrevention(t *testing.T) {
+	type outcome struct {
+		status   string
+		reason   string
+		filled   int64
+		trades   int
+		ownAsk   bool
+		askTotal int64
+	}
+
+	for _, tc := range []struct {
+		name  string
+		stp   orderbook.SelfTradePrevention
+		extra int64
+		want  outcome
+	}{
+		{"none/short", orderbook.STPNone, 2, outcome{StatusFilled, "", 8, 2, false, 2}},
+		{"cancel newest/short", orderbook.STPCancelNewest, 2, outcome{StatusCancelled, ReasonFillOrKill, 0, 0, true, 10}},
+		{"cancel oldest/short", orderbook.STPCancelOldest, 2, outcome{StatusCancelled, ReasonFillOrKill, 0, 0, true, 10}},
+		{"cancel both/short", orderbook.STPCancelBoth, 2, outcome{StatusCancelled, ReasonFillOrKill, 0, 0, true, 10}},
+		{"decrement/short", orderbook.STPDecrementAndCancel, 2, outcome{StatusCancelled, ReasonFillOrKill, 0, 0, true, 10}},
+
+		{"none/enough", orderbook.STPNone, 5, outcome{StatusFilled, "", 8, 2, false, 5}},
+		{"cancel newest/enough", orderbook.STPCancelNewest, 5, outcome{StatusCancelled, ReasonFillOrKill, 0, 0, true, 13}},
+		{"cancel oldest/enough", orderbook.STPCancelOldest, 5, outcome{StatusFilled, "", 8, 2, false, 2}},
+		{"cancel both/enough", orderbook.STPCancelBoth, 5, outcome{StatusCancelled, ReasonFillOrKill, 0, 0, true, 13}},
+		{"decrement/enough", orderbook.STPDecrementAndCancel, 5, outcome{StatusCancelled, ReasonFillOrKill, 0, 0, true, 13}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMatcher(nil, nil)
+			m.AddMarket(MarketRules{Symbol: stpSymbol, Scale: orderbook.DefaultScale})
+			m.ProcessOrder(orderbook.NewOrder("a1", "m", stpSymbol, orderbook.Sell, orderbook.Limit, 100, 5))
+			m.ProcessOrder(orderbook.NewOrder("a2", "t", stpSymbol, orderbook.Sell, orderbook.Limit, 100, 3))
+			m.ProcessOrder(orderbook.NewOrder("a3", "m", stpSymbol, orderbook.Sell, orderbook.Limit, 101, tc.extra))
+
+			taker := orderbook.NewOrder("t1", "t", stpSymbol, orderbook.Buy, orderbook.Limit, 101, 8)
+			taker.TimeInForce = orderbook.FOK
+			taker.STP = tc.stp
+			result := m.ProcessOrder(taker)
+
+			last := result.OrderUpdates[len(result.OrderUpdates)-1]
+			ob := m.GetOrderbook(stpSymbol)
+			var askTotal int64
+			ob.Asks.Walk(func(level *orderbook.PriceLevel) bool {
+				askTotal += level.Volume
+				return true
+			})
+			got := outcome{last.Status, last.Reason, last.FilledQty, len(result.Trades), ob.GetOrder("a2") != nil, askTotal}
+			if last.OrderID != "t1" || got != tc.want {
+				t.Fatalf("taker %s: got %+v, want %+v", last.OrderID, got, tc.want)
+			}
+			if ob.GetOrder("t1") != nil {
+				t.Fatal("FOK order rests on the book")
+			}
+		})
+	}
+}
+
+// TestMarketSelfTradePrevention checks that orders without a mode of their own
+// take their market's, and that one they choose overrides it.
+func TestMarketSelfTradePrevention(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		market orderbook.SelfTradePrevention
+		order  orderbook.SelfTradePrevention
+		want   string
+	}{
+		{"no market mode", orderbook.STPDefault, orderbook.STPDefault, StatusFilled},
+		{"market mode", orderbook.STPCancelNewest, orderbook.STPDefault, StatusCancelled},
+		{"order overrides market", orderbook.STPCancelNewest, orderbook.STPNone, StatusFilled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewMatcher(nil, nil)
+			m.AddMarket(MarketRules{Symbol: stpSymbol, Scale: orderbook.DefaultScale, STP: tc.market})
+			m.ProcessOrder(orderbook.NewOrder("a1", "t", stpSymbol, orderbook.Sell, orderbook.Limit, 100, 5))
+
+			taker := orderbook.NewOrder("t1", "t", stpSymbol, orderbook.Buy, orderbook.Limit, 100, 5)
+			taker.STP = tc.order
+			result := m.ProcessOrder(taker)
+
+			if last := result.OrderUpdates[len(result.OrderUpdates)-1]; last.OrderID != "t1" || last.Status != tc.want {
+				t.Fatalf("taker ended %+v, want %s", last, tc.want)
+			}
+		})
+	}
+}
+
+const testSymbol = "BTC/USDT"
+
+func newTestMatcher(t *testing.T, rules MarketRules) *Matcher {
+	t.Helper()
+	rules.Symbol = testSymbol
+	if rules.Scale == (orderbook.Scale{}) {
+		rules.Scale = orderbook.DefaultScale
+	}
+	m := NewMatcher(nil, nil)
+	m.AddMarket(rules)
+	return m
+}
+
+func limit(id, userID string, side orderbook.Side, price, qty int64) *orderbook.Order {
+	return orderbook.NewOrder(id, userID, testSymbol, side, orderbook.Limit, price, qty)
+}
+
+// queue lists the IDs of the orders at price on side, front first.
+func queue(m *Matcher, side orderbook.Side, price int64) []string {
+	bs := m.GetOrderbook(testSymbol).Asks
+	if side == orderbook.Buy {
+		bs = m.GetOrderbook(testSymbol).Bids
+	}
+	level := bs.GetLevel(price)
+	if level == nil {
+		return nil
+	}
+	var ids []string
+	for e := level.Orders.Front(); e != nil; e = e.Next() {
+		ids = append(ids, e.Value.(*orderbook.Order).ID)
+	}
+	return ids
+}
+
+// lastUpdate returns the last execution report for orderID in result.
+func lastUpdate(t *testing.T, result *MatchResult, orderID string) *OrderUpdate {
+	t.Helper()
+	for i := len(result.OrderUpdates) - 1; i >= 0; i-- {
+		if u := result.OrderUpdates[i]; u.OrderID == orderID {
+			return u
+		}
+	}
+	t.Fatalf("no update for %s in %+v", orderID, result.OrderUpdates)
+	return nil
+}
+
+// TestAmendPriority rests a1, a2 and a3 at 100 and amends a1. Only a
+// quantity cut at the same price keeps its place at the front.
+func TestAmendPriority(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		price, qty   int64
+		want100      string
+		want101      string
+		remainingQty int64
+	}{
+		{"quantity down", 0, 3, "[a1 a2 a3]", "[]", 3},
+		{"same quantity", 100, 5, "[a1 a2 a3]", "[]", 5},
+		{"quantity up", 0, 8, "[a2 a3 a1]", "[]", 8},
+		{"price away", 101, 0, "[a2 a3]", "[a1]", 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newTestMatcher(t, MarketRules{})
+			for _, id := range []string{"a1", "a2", "a3"} {
+				m.ProcessOrder(limit(id, "m", orderbook.Sell, 100, 5))
+			}
+
+			result := m.AmendOrder(testSymbol, "a1", tc.price, tc.qty)
+			if u := lastUpdate(t, result, "a1"); u.Status != StatusNew || u.Reason != ReasonAmended || u.RemainingQty != tc.remainingQty {
+				t.Fatalf("amend reported %+v", u)
+			}
+			if got := fmt.Sprint(queue(m, orderbook.Sell, 100)); got != tc.want100 {
+				t.Fatalf("queue at 100 is %s, want %s", got, tc.want100)
+			}
+			if got := fmt.Sprint(queue(m, orderbook.Sell, 101)); got != tc.want101 {
+				t.Fatalf("queue at 101 is %s, want %s", got, tc.want101)
+			}
+		})
+	}
+}
+
+// TestAmendPriceBackLosesPriority moves a1 away and back: it returns behind
+// the orders that stayed.
+func TestAmendPriceBackLosesPriority(t *testing.T) {
+	m := newTestMatcher(t, MarketRules{})
+	for _, id := range []string{"a1", "a2"} {
+		m.ProcessOrder(limit(id, "m", orderbook.Sell, 100, 5))
+	}
+	m.AmendOrder(testSymbol, "a1", 101, 0)
+	m.AmendOrder(testSymbol, "a1", 100, 0)
+
+	if got := fmt.Sprint(queue(m, orderbook.Sell, 100)); got != "[a2 a1]" {
+		t.Fatalf("queue at 100 is %s, want [a2 a1]", got)
+	}
+
+	// The next buyer trades with a2 first.
+	result := m.ProcessOrder(limit("b1", "t", orderbook.Buy, 100, 5))
+	if len(result.Trades) != 1 || result.Trades[0].MakerOrderID != "a2" {
+		t.Fatalf("trades %+v, want one with a2", result.Trades)
+	}
+}
+
+// newStopBook trades at 100 and rests one lot at each of asks 101, 102 and
+// 103 and bids 99, 98 and 97.
+func newStopBook(t *testing.T) *Matcher {
+	t.Helper()
+	m := newTestMatcher(t, MarketRules{})
+	m.ProcessOrder(limit("x1", "x", orderbook.Sell, 100, 1))
+	m.ProcessOrder(limit("x2", "y", orderbook.Buy, 100, 1))
+	for i, price := range []int64{101, 102, 103} {
+		m.ProcessOrder(limit(fmt.Sprintf("a%d", i), "m", orderbook.Sell, price, 1))
+	}
+	for i, price := range []int64{99, 98, 97} {
+		m.ProcessOrder(limit(fmt.Sprintf("b%d", i), "m", orderbook.Buy, price, 1))
+	}
+	return m
+}
+
+func stop(id string, side orderbook.Side, orderType orderbook.OrderType, stopPrice, price int64) *orderbook.Order {
+	order := orderbook.NewOrder(id, "s", testSymbol, side, orderType, price, 1)
+	order.StopPrice = stopPrice
+	return order
+}
+
+func tradePrices(result *MatchResult) string {
+	var prices []int64
+	for _, trade := range result.Trades {
+		prices = append(prices, trade.Price)
+	}
+	return fmt.Sprint(prices)
+}
+
+// TestStopTriggers places a conditional order, then trades once at price.
+// The order ends up still waiting for its stop, triggered and resting, or
+// triggered and filled.
+func TestStopTriggers(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		order  *orderbook.Order
+		side   orderbook.Side
+		price  int64
+		state  string
+		trades string
+	}{
+		{"buy stop on a rise", stop("s1", orderbook.Buy, orderbook.StopMarket, 101, 0), orderbook.Buy, 101, "filled", "[101 102]"},
+		{"buy stop below its price", stop("s1", orderbook.Buy, orderbook.StopMarket, 102, 0), orderbook.Buy, 101, "waiting", "[101]"},
+		{"buy stop on a fall", stop("s1", orderbook.Buy, orderbook.StopMarket, 101, 0), orderbook.Sell, 99, "waiting", "[99]"},
+		{"sell stop on a fall", stop("s1", orderbook.Sell, orderbook.StopMarket, 99, 0), orderbook.Sell, 99, "filled", "[99 98]"},
+		{"stop limit rests when triggered", stop("s1", orderbook.Buy, orderbook.StopLimit, 101, 101), orderbook.Buy, 101, "resting", "[101]"},
+		{"stop limit trades when triggered", stop("s1", orderbook.Buy, orderbook.StopLimit, 101, 102), orderbook.Buy, 101, "filled", "[101 102]"},
+		{"take profit on a rise", stop("s1", orderbook.Sell, orderbook.TakeProfit, 101, 0), orderbook.Buy, 101, "filled", "[101 99]"},
+		{"take profit not on a fall", stop("s1", orderbook.Sell, orderbook.TakeProfit, 101, 0), orderbook.Sell, 99, "waiting", "[99]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newStopBook(t)
+			placed := m.ProcessOrder(tc.order)
+			if u := lastUpdate(t, placed, "s1"); u.Status != StatusNew || len(placed.Trades) > 0 || placed.OrderbookDelta != nil {
+				t.Fatalf("placing the stop gave %+v", placed)
+			}
+
+			result := m.ProcessOrder(limit("t1", "t", tc.side, tc.price, 1))
+			if got := tradePrices(result); got != tc.trades {
+				t.Fatalf("traded at %s, want %s", got, tc.trades)
+			}
+
+			ob := m.GetOrderbook(testSymbol)
+			state := "filled"
+			switch {
+			case ob.Triggers.Get("s1") != nil:
+				state = "waiting"
+			case ob.GetOrder("s1") != nil:
+				state = "resting"
+			}
+			if state != tc.state {
+				t.Fatalf("s1 is %s, want %s", state, tc.state)
+			}
+			if state == "filled" {
+				if u := lastUpdate(t, result, "s1"); u.Status != StatusFilled {
+					t.Fatalf("s1 ended %s", u.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestStopThatWouldTriggerIsRejected(t *testing.T) {
+	m := newStopBook(t)
+	result := m.ProcessOrder(stop("s1", orderbook.Buy, orderbook.StopMarket, 100, 0))
+	if u := lastUpdate(t, result, "s1"); u.Status != StatusRejected || u.Reason != ReasonWouldTrigger {
+		t.Fatalf("got %+v, want REJECTED %s", u, ReasonWouldTrigger)
+	}
+	if m.GetOrderbook(testSymbol).Triggers.Len() != 0 {
+		t.Fatal("rejected stop is waiting")
+	}
+}
+
+func TestCancelWaitingStop(t *testing.T) {
+	m := newStopBook(t)
+	m.ProcessOrder(stop("s1", orderbook.Buy, orderbook.StopMarket, 101, 0))
+	seq := m.GetOrderbook(testSymbol).GetSequence()
+
+	result := m.CancelOrder(testSymbol, "s1")
+	if result == nil {
+		t.Fatal("waiting stop not found")
+	}
+	if u := lastUpdate(t, result, "s1"); u.Status != StatusCancelled || u.Reason != ReasonUserCancelled {
+		t.Fatalf("got %+v, want CANCELLED %s", u, ReasonUserCancelled)
+	}
+	if result.OrderbookDelta != nil || m.GetOrderbook(testSymbol).GetSequence() != seq {
+		t.Fatal("cancelling a waiting stop changed the book")
+	}
+	if view := m.View(testSymbol); view.Triggers != 0 {
+		t.Fatal("cancelled stop is still published")
+	}
+
+	result = m.ProcessOrder(limit("t1", "t", orderbook.Buy, 101, 1))
+	if got := tradePrices(result); got != "[101]" {
+		t.Fatalf("cancelled stop fired: traded at %s", got)
+	}
+	if m.CancelOrder(testSymbol, "s1") != nil {
+		t.Fatal("cancelled twice")
+	}
+}
+
+// TestStopCascade chains stops: s1's own trade at 102 reaches s2, whose
+// trade at 103 reaches s3. Each goes in turn, in the same result.
+func TestStopCascade(t *testing.T) {
+	m := newStopBook(t)
+	m.ProcessOrder(stop("s3", orderbook.Buy, orderbook.StopMarket, 103, 0))
+	m.ProcessOrder(stop("s1", orderbook.Buy, orderbook.StopMarket, 101, 0))
+	m.ProcessOrder(stop("s2", orderbook.Buy, orderbook.StopMarket, 102, 0))
+	// Not reached by the cascade: the price only rises.
+	m.ProcessOrder(stop("s4", orderbook.Sell, orderbook.StopMarket, 99, 0))
+
+	result := m.ProcessOrder(limit("t1", "t", orderbook.Buy, 101, 1))
+	if got := tradePrices(result); got != "[101 102 103]" {
+		t.Fatalf("traded at %s, want [101 102 103]", got)
+	}
+	for i, id := range []string{"t1", "s1", "s2"} {
+		if taker := result.Trades[i].TakerOrderID; taker != id {
+			t.Fatalf("trade %d taken by %s, want %s", i, taker, id)
+		}
+		if u := lastUpdate(t, result, id); u.Status != StatusFilled {
+			t.Fatalf("%s ended %s", id, u.Status)
+		}
+	}
+	// s3 found the asks empty once it fired.
+	if u := lastUpdate(t, result, "s3"); u.Status != StatusCancelled || u.Reason != ReasonNoLiquidity {
+		t.Fatalf("s3 ended %+v, want CANCELLED %s", u, ReasonNoLiquidity)
+	}
+	if ob := m.GetOrderbook(testSymbol); ob.Triggers.Len() != 1 || ob.Triggers.Get("s4") == nil {
+		t.Fatalf("%d stops still waiting, want only s4", ob.Triggers.Len())
+	}
+}
+
+func iceberg(id string, side orderbook.Side, price, qty, display int64) *orderbook.Order {
+	order := limit(id, "m", side, price, qty)
+	order.DisplayQty = display
+	return order
+}
+
+func tradeQtys(result *MatchResult) string {
+	var qtys []string
+	for _, trade := range result.Trades {
+		qtys = append(qtys, fmt.Sprintf("%s:%d", trade.MakerOrderID, trade.Quantity))
+	}
+	return fmt.Sprint(qtys)
+}
+
+// TestIcebergShowsOnlyItsSlice checks that the book, its depth and its
+// deltas carry only the shown slice of an iceberg.
+func TestIcebergShowsOnlyItsSlice(t *testing.T) {
+	m := newTestMatcher(t, MarketRules{})
+	result := m.ProcessOrder(iceberg("i1", orderbook.Sell, 100, 10, 3))
+	if got := fmt.Sprint(result.OrderbookDelta.Asks); got != "[[100 3]]" {
+		t.Fatalf("placing delta %s, want [[100 3]]", got)
+	}
+	if u := lastUpdate(t, result, "i1"); u.Status != StatusNew || u.RemainingQty != 10 {
+		t.Fatalf("placing reported %+v", u)
+	}
+
+	// Taking 2 of the slice leaves 1 shown.
+	result = m.ProcessOrder(limit("t1", "t", orderbook.Buy, 100, 2))
+	if got := fmt.Sprint(result.OrderbookDelta.Asks); got != "[[100 1]]" {
+		t.Fatalf("delta after a partial take %s, want [[100 1]]", got)
+	}
+	// Taking the rest of the slice shows the next one.
+	result = m.ProcessOrder(limit("t2", "t", orderbook.Buy, 100, 1))
+	if got := fmt.Sprint(result.OrderbookDelta.Asks); got != "[[100 3]]" {
+		t.Fatalf("delta after a refill %s, want [[100 3]]", got)
+	}
+	if u := lastUpdate(t, result, "i1"); u.Status != StatusPartial || u.RemainingQty != 7 {
+		t.Fatalf("refilled iceberg reported %+v", u)
+	}
+
+	_, asks := m.GetOrderbook(testSymbol).GetDepth(10)
+	if got := fmt.Sprint(asks); got != "[[100 3]]" {
+		t.Fatalf("depth %s, want [[100 3]]", got)
+	}
+	view := m.View(testSymbol)
+	if _, asks := view.Depth(10); fmt.Sprint(asks) != "[[100 3]]" {
+		t.Fatalf("published depth %v, want [[100 3]]", asks)
+	}
+}
+
+// TestIcebergRefillsAtTheBack rests iceberg i1 ahead of a2. Once its slice
+// is taken, i1 shows a new one behind a2.
+func TestIcebergRefillsAtTheBack(t *testing.T) {
+	m := newTestMatcher(t, MarketRules{})
+	m.ProcessOrder(iceberg("i1", orderbook.Sell, 100, 10, 3))
+	m.ProcessOrder(limit("a2", "m", orderbook.Sell, 100, 2))
+
+	m.ProcessOrder(limit("t1", "t", orderbook.Buy, 100, 2))
+	if got := fmt.Sprint(queue(m, orderbook.Sell, 100)); got != "[i1 a2]" {
+		t.Fatalf("queue %s after part of the slice, want [i1 a2]", got)
+	}
+
+	m.ProcessOrder(limit("t2", "t", orderbook.Buy, 100, 1))
+	if got := fmt.Sprint(queue(m, orderbook.Sell, 100)); got != "[a2 i1]" {
+		t.Fatalf("queue %s after the slice, want [a2 i1]", got)
+	}
+
+	result := m.ProcessOrder(limit("t3", "t", orderbook.Buy, 100, 3))
+	if got := tradeQtys(result); got != "[a2:2 i1:1]" {
+		t.Fatalf("trades %s, want [a2:2 i1:1]", got)
+	}
+}
+
+// TestSweepTakesHiddenQuantity sends a taker bigger than every shown slice.
+// It keeps taking refilled slices of the iceberg, in turn with a2, until it
+// is filled.
+func TestSweepTakesHiddenQuantity(t *testing.T) {
+	m := newTestMatcher(t, MarketRules{})
+	m.ProcessOrder(iceberg("i1", orderbook.Sell, 100, 10, 3))
+	m.ProcessOrder(limit("a2", "m", orderbook.Sell, 100, 2))
+	m.ProcessOrder(limit("a3", "m", orderbook.Sell, 101, 5))
+
+	result := m.ProcessOrder(limit("t1", "t", orderbook.Buy, 100, 11))
+	if got := tradeQtys(result); got != "[i1:3 a2:2 i1:3 i1:3]" {
+		t.Fatalf("trades %s, want [i1:3 a2:2 i1:3 i1:3]", got)
+	}
+	if u := lastUpdate(t, result, "t1"); u.Status != StatusFilled {
+		t.Fatalf("taker ended %s", u.Status)
+	}
+	if got := fmt.Sprint(result.OrderbookDelta.Asks); got != "[[100 1]]" {
+		t.Fatalf("delta %s, want [[100 1]]", got)
+	}
+
+	// A market sweep takes the last hidden lot before the next level.
+	result = m.ProcessOrder(orderbook.NewOrder("t2", "t", testSymbol, orderbook.Buy, orderbook.Market, 0, 3))
+	if got := tradeQtys(result); got != "[i1:1 a3:2]" {
+		t.Fatalf("trades %s, want [i1:1 a3:2]", got)
+	}
+	if u := lastUpdate(t, result, "i1"); u.Status != StatusFilled {
+		t.Fatalf("iceberg ended %s", u.Status)
+	}
+}
+
+// newCancelAllBooks rests orders by u1 and u2 in BTC/USDT and ETH/USDT, and
+// a stop by each user waiting in BTC/USDT.
+func newCancelAllBooks(t *testing.T) *Matcher {
+	t.Helper()
+	m := NewMatcher(nil, nil)
+	for _, symbol := range []string{"BTC/USDT", "ETH/USDT"} {
+		m.AddMarket(MarketRules{Symbol: symbol, Scale: orderbook.DefaultScale})
+	}
+	place := func(id, userID, symbol string, side orderbook.Side, price int64) {
+		m.ProcessOrder(orderbook.NewOrder(id, userID, symbol, side, orderbook.Limit, price, 2))
+	}
+	place("u1-btc-bid", "u1", "BTC/USDT", orderbook.Buy, 99)
+	place("u1-btc-ask", "u1", "BTC/USDT", orderbook.Sell, 101)
+	place("u2-btc-bid", "u2", "BTC/USDT", orderbook.Buy, 99)
+	place("u1-eth-bid", "u1", "ETH/USDT", orderbook.Buy, 49)
+	place("u2-eth-ask", "u2", "ETH/USDT", orderbook.Sell, 51)
+
+	// A trade sets the last price the stops wait on.
+	m.ProcessOrder(orderbook.NewOrder("x1", "x", "BTC/USDT", orderbook.Sell, orderbook.Limit, 100, 1))
+	m.ProcessOrder(orderbook.NewOrder("x2", "y", "BTC/USDT", orderbook.Buy, orderbook.Limit, 100, 1))
+	for _, userID := range []string{"u1", "u2"} {
+		order := orderbook.NewOrder(userID+"-btc-stop", userID, "BTC/USDT", orderbook.Sell, orderbook.StopMarket, 0, 1)
+		order.StopPrice = 90
+		m.ProcessOrder(order)
+	}
+	return m
+}
+
+func TestCancelAll(t *testing.T) {
+	sell := orderbook.Sell
+	for _, tc := range []struct {
+		name   string
+		filter CancelFilter
+		// want lists the cancelled orders of each book changed, by symbol.
+		want string
+		// deltas lists the levels each book's delta changed.
+		deltas string
+	}{
+		{"user in one symbol", CancelFilter{UserID: "u1", Symbol: "BTC/USDT"},
+			"[BTC/USDT:[u1-btc-ask u1-btc-bid u1-btc-stop]]", "[BTC/USDT:[[99 2]][[101 0]]]"},
+		{"user in every symbol", CancelFilter{UserID: "u1"},
+			"[BTC/USDT:[u1-btc-ask u1-btc-bid u1-btc-stop] ETH/USDT:[u1-eth-bid]]", "[BTC/USDT:[[99 2]][[101 0]] ETH/USDT:[[49 0]][]]"},
+		{"every user in one symbol", CancelFilter{Symbol: "ETH/USDT"},
+			"[ETH/USDT:[u1-eth-bid u2-eth-ask]]", "[ETH/USDT:[[49 0]][[51 0]]]"},
+		{"one side", CancelFilter{UserID: "u1", Side: &sell},
+			"[BTC/USDT:[u1-btc-ask u1-btc-stop]]", "[BTC/USDT:[][[101 0]]]"},
+		{"waiting stops only", CancelFilter{UserID: "u2", Side: &sell, Symbol: "BTC/USDT"},
+			"[BTC/USDT:[u2-btc-stop]]", "[BTC/USDT:none]"},
+		{"nothing matches", CancelFilter{UserID: "u3"}, "[]", "[]"},
+		{"unknown symbol", CancelFilter{Symbol: "DOGE/USDT"}, "[]", "[]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newCancelAllBooks(t)
+			results := m.CancelAll(tc.filter, ReasonMassCancel)
+
+			var cancelled, deltas []string
+			n := 0
+			for _, result := range results {
+				var ids []string
+				for _, u := range result.OrderUpdates {
+					if u.Status != StatusCancelled || u.Reason != ReasonMassCancel || u.FilledQty != 0 || u.Symbol != result.Symbol {
+						t.Fatalf("update %+v", u)
+					}
+					ids = append(ids, u.OrderID)
+					n++
+					ob := m.GetOrderbook(result.Symbol)
+					if ob.GetOrder(u.OrderID) != nil || ob.Triggers.Get(u.OrderID) != nil {
+						t.Fatalf("%s is still in the book", u.OrderID)
+					}
+				}
+				cancelled = append(cancelled, fmt.Sprintf("%s:%v", result.Symbol, ids))
+				if d := result.OrderbookDelta; d != nil {
+					deltas = append(deltas, fmt.Sprintf("%s:%v%v", result.Symbol, d.Bids, d.Asks))
+				} else {
+					deltas = append(deltas, result.Symbol+":none")
+				}
+				if len(result.Trades) != 0 {
+					t.Fatalf("cancelling traded: %+v", result.Trades)
+				}
+			}
+			if got := fmt.Sprint(cancelled); got != tc.want {
+				t.Fatalf("cancelled %s, want %s", got, tc.want)
+			}
+			if got := fmt.Sprint(deltas); got != tc.deltas {
+				t.Fatalf("deltas %s, want %s", got, tc.deltas)
+			}
+
+			// Everything the filter does not match is left alone.
+			left := 0
+			for _, view := range m.Views() {
+				left += view.Orders + view.Triggers
+			}
+			if left != 7-n {
+				t.Fatalf("%d orders left after cancelling %d of 7", left, n)
+			}
+		})
+	}
+}
@@ -2,22 +2,24 @@ package orderbook
 
 import (
 	"container/list"
-
-	"github.com/shopspring/decimal"
 )
 
 type PriceLevel struct {
-	Price    decimal.Decimal
+	Price    int64
 	Orders   *list.List
-	Volume   decimal.Decimal
+	Volume   int64
 	elements map[string]*list.Element
+
+	// side, if set, is told the first time the level changes after each
+	// publish.
+	side    *BookSide
+	changed bool
 }
 
-func NewPriceLevel(price decimal.Decimal) *PriceLevel {
+func NewPriceLevel(price int64) *PriceLevel {
 	return &PriceLevel{
 		Price:    price,
 		Orders:   list.New(),
-		Volume:   decimal.Zero,
 		elements: make(map[string]*list.Element),
 	}
 }
@@ -25,7 +27,8 @@ func NewPriceLevel(price decimal.Decimal) *PriceLevel {
 func (pl *PriceLevel) AddOrder(order *Order) {
 	elem := pl.Orders.PushBack(order)
 	pl.elements[order.ID] = elem
-	pl.Volume = pl.Volume.Add(order.RemainingQty)
+	pl.Volume += order.Visible()
+	pl.touch()
 }
 
 func (pl *PriceLevel) RemoveOrder(orderID string) *Order {
@@ -37,13 +40,23 @@ func (pl *PriceLevel) RemoveOrder(orderID string) *Order {
 	order := elem.Value.(*Order)
 	pl.Orders.Remove(elem)
 	delete(pl.elements, orderID)
-	pl.Volume = pl.Volume.Sub(order.RemainingQty)
+	pl.Volume -= order.Visible()
+	pl.touch()
 
 	return order
 }
 
-func (pl *PriceLevel) UpdateVolume(delta decimal.Decimal) {
-	pl.Volume = pl.Volume.Add(delta)
+func (pl *PriceLevel) UpdateVolume(delta int64) {
+	pl.Volume += delta
+	pl.touch()
+}
+
+func (pl *PriceLevel) touch() {
+	if pl.side == nil || pl.changed {
+		return
+	}
+	pl.changed = true
+	pl.side.changed = append(pl.side.changed, pl)
 }
 
 func (pl *PriceLevel) Front() *Order {
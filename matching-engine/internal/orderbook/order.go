@@ -1,9 +1,8 @@
 package orderbook
 
 import (
+	"fmt"
 	"time"
-
-	"github.com/shopspring/decimal"
 )
 
 type Side int
@@ -25,21 +24,166 @@ type OrderType int
 const (
 	Limit OrderType = iota
 	Market
+	// Conditional types wait in the book's TriggerBook until the last trade
+	// price reaches StopPrice and then match as their Triggered type.
+	StopMarket
+	StopLimit
+	TakeProfit
+)
+
+func (t OrderType) String() string {
+	switch t {
+	case Market:
+		return "MARKET"
+	case StopMarket:
+		return "STOP_MARKET"
+	case StopLimit:
+		return "STOP_LIMIT"
+	case TakeProfit:
+		return "TAKE_PROFIT"
+	default:
+		return "LIMIT"
+	}
+}
+
+func (t OrderType) IsConditional() bool {
+	return t == StopMarket || t == StopLimit || t == TakeProfit
+}
+
+// Triggered returns the type a conditional order matches as once triggered.
+func (t OrderType) Triggered() OrderType {
+	switch t {
+	case StopLimit:
+		return Limit
+	case StopMarket, TakeProfit:
+		return Market
+	default:
+		return t
+	}
+}
+
+type TimeInForce int
+
+const (
+	GTC TimeInForce = iota
+	IOC
+	FOK
+	PostOnly
 )
 
+func (t TimeInForce) String() string {
+	switch t {
+	case IOC:
+		return "IOC"
+	case FOK:
+		return "FOK"
+	case PostOnly:
+		return "POST_ONLY"
+	default:
+		return "GTC"
+	}
+}
+
+// ParseTimeInForce maps the wire value to a TimeInForce; an empty string
+// means GTC.
+func ParseTimeInForce(s string) (TimeInForce, error) {
+	switch s {
+	case "", "GTC":
+		return GTC, nil
+	case "IOC":
+		return IOC, nil
+	case "FOK":
+		return FOK, nil
+	case "POST_ONLY":
+		return PostOnly, nil
+	default:
+		return GTC, fmt.Errorf("unknown time in force %q", s)
+	}
+}
+
+type SelfTradePrevention int
+
+const (
+	// STPDefault defers to the market's configured mode.
+	STPDefault SelfTradePrevention = iota
+	STPNone
+	STPCancelNewest
+	STPCancelOldest
+	STPCancelBoth
+	STPDecrementAndCancel
+)
+
+func (s SelfTradePrevention) String() string {
+	switch s {
+	case STPNone:
+		return "NONE"
+	case STPCancelNewest:
+		return "CANCEL_NEWEST"
+	case STPCancelOldest:
+		return "CANCEL_OLDEST"
+	case STPCancelBoth:
+		return "CANCEL_BOTH"
+	case STPDecrementAndCancel:
+		return "DECREMENT_AND_CANCEL"
+	default:
+		return "DEFAULT"
+	}
+}
+
+func ParseSelfTradePrevention(s string) (SelfTradePrevention, error) {
+	switch s {
+	case "", "DEFAULT":
+		return STPDefault, nil
+	case "NONE":
+		return STPNone, nil
+	case "CANCEL_NEWEST":
+		return STPCancelNewest, nil
+	case "CANCEL_OLDEST":
+		return STPCancelOldest, nil
+	case "CANCEL_BOTH":
+		return STPCancelBoth, nil
+	case "DECREMENT_AND_CANCEL":
+		return STPDecrementAndCancel, nil
+	default:
+		return STPDefault, fmt.Errorf("unknown self-trade prevention mode %q", s)
+	}
+}
+
+// Order prices are in ticks and quantities in lots of the market's Scale.
 type Order struct {
-	ID           string
-	UserID       string
-	Symbol       string
-	Side         Side
-	Type         OrderType
-	Price        decimal.Decimal
-	Quantity     decimal.Decimal
-	RemainingQty decimal.Decimal
-	Timestamp    time.Time
-}
-
-func NewOrder(id, userID, symbol string, side Side, orderType OrderType, price, quantity decimal.Decimal) *Order {
+	ID           string              `json:"id"`
+	UserID       string              `json:"userId"`
+	Symbol       string              `json:"symbol"`
+	Side         Side                `json:"side"`
+	Type         OrderType           `json:"type"`
+	TimeInForce  TimeInForce         `json:"timeInForce"`
+	STP          SelfTradePrevention `json:"stp"`
+	Price        int64               `json:"price"`
+	Quantity     int64               `json:"quantity"`
+	RemainingQty int64               `json:"remainingQty"`
+	FilledQty    int64               `json:"filledQty"`
+	FilledQuote  Quote               `json:"filledQuote"`
+	Timestamp    time.Time           `json:"timestamp"`
+
+	// QuoteQty, when set on a market buy, is the amount of quote to spend in
+	// place of a base Quantity. Quantity is then zero and RemainingQty is
+	// what the unspent amount buys at the level being matched.
+	QuoteQty Quote `json:"quoteQty"`
+
+	StopPrice int64 `json:"stopPrice"`
+
+	// MaxSlippageBps, if positive, stops matching at that many basis points
+	// past the best opposite price at arrival.
+	MaxSlippageBps int64 `json:"maxSlippageBps"`
+
+	// DisplayQty, if positive, makes a resting order an iceberg that shows
+	// at most that much at a time. VisibleQty is what is left of the shown
+	// slice; the rest of RemainingQty is hidden.
+	DisplayQty int64 `json:"displayQty"`
+	VisibleQty int64 `json:"visibleQty"`
+}
+
+func NewOrder(id, userID, symbol string, side Side, orderType OrderType, price, quantity int64) *Order {
 	return &Order{
 		ID:           id,
 		UserID:       userID,
@@ -49,18 +193,86 @@ func NewOrder(id, userID, symbol string, side Side, orderType OrderType, price,
 		Price:        price,
 		Quantity:     quantity,
 		RemainingQty: quantity,
-		Timestamp:    time.Now(),
+	}
+}
+
+// TriggersOnRise reports whether a conditional order fires when the last
+// trade price rises to its stop price rather than falls to it.
+func (o *Order) TriggersOnRise() bool {
+	if o.Type == TakeProfit {
+		return o.Side == Sell
+	}
+	return o.Side == Buy
+}
+
+func (o *Order) StopReached(lastPrice int64) bool {
+	if lastPrice == 0 {
+		return false
+	}
+	if o.TriggersOnRise() {
+		return lastPrice >= o.StopPrice
+	}
+	return lastPrice <= o.StopPrice
+}
+
+func (o *Order) IsQuoteOrder() bool {
+	return !o.QuoteQty.IsZero()
+}
+
+func (o *Order) IsIceberg() bool {
+	return o.DisplayQty > 0
+}
+
+// Visible returns the quantity the order shows on the book.
+func (o *Order) Visible() int64 {
+	if o.IsIceberg() {
+		return o.VisibleQty
+	}
+	return o.RemainingQty
+}
+
+// Refresh shows a new slice of an iceberg's reserve.
+func (o *Order) Refresh() {
+	if !o.IsIceberg() {
+		return
+	}
+	o.VisibleQty = o.DisplayQty
+	if o.RemainingQty < o.VisibleQty {
+		o.VisibleQty = o.RemainingQty
 	}
 }
 
 func (o *Order) IsFilled() bool {
-	return o.RemainingQty.IsZero()
+	return o.RemainingQty == 0
 }
 
-func (o *Order) Fill(qty decimal.Decimal) decimal.Decimal {
-	if qty.GreaterThan(o.RemainingQty) {
+func (o *Order) Fill(qty, price int64) int64 {
+	if qty > o.RemainingQty {
 		qty = o.RemainingQty
 	}
-	o.RemainingQty = o.RemainingQty.Sub(qty)
+	o.RemainingQty -= qty
+	o.FilledQty += qty
+	o.useVisible(qty)
+	o.FilledQuote = o.FilledQuote.Add(MulQuote(price, qty))
 	return qty
 }
+
+// Reduce takes qty off the remaining quantity without executing it.
+func (o *Order) Reduce(qty int64) int64 {
+	if qty > o.RemainingQty {
+		qty = o.RemainingQty
+	}
+	o.RemainingQty -= qty
+	o.useVisible(qty)
+	return qty
+}
+
+func (o *Order) useVisible(qty int64) {
+	if !o.IsIceberg() {
+		return
+	}
+	o.VisibleQty -= qty
+	if o.VisibleQty < 0 {
+		o.VisibleQty = 0
+	}
+}
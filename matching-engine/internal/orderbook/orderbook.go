@@ -1,36 +1,77 @@
 package orderbook
 
 import (
-	"sync"
-
-	"github.com/shopspring/decimal"
+	"sort"
+	"sync/atomic"
 )
 
+// An Orderbook belongs to a single writer goroutine at a time and is not
+// safe for concurrent use. Other goroutines read the View it publishes.
 type Orderbook struct {
 	Symbol   string
+	Scale    Scale
 	Bids     *BookSide
 	Asks     *BookSide
 	Orders   map[string]*Order
 	Sequence uint64
-	mu       sync.RWMutex
+
+	// LastTradePrice is the price of the most recent trade, or 0 if none.
+	LastTradePrice int64
+
+	Triggers *TriggerBook
+
+	// byUser indexes resting orders by user ID and then order ID.
+	byUser map[string]map[string]*Order
+
+	view atomic.Pointer[View]
 }
 
-func NewOrderbook(symbol string) *Orderbook {
-	return &Orderbook{
+func NewOrderbook(symbol string, scale Scale) *Orderbook {
+	ob := &Orderbook{
 		Symbol:   symbol,
-		Bids:     NewBookSide(true),
-		Asks:     NewBookSide(false),
+		Scale:    scale,
+		Bids:     newPublishedSide(true),
+		Asks:     newPublishedSide(false),
 		Orders:   make(map[string]*Order),
 		Sequence: 0,
+		Triggers: NewTriggerBook(),
+		byUser:   make(map[string]map[string]*Order),
 	}
+	ob.Publish()
+	return ob
 }
 
-func (ob *Orderbook) AddOrder(order *Order) {
-	ob.mu.Lock()
-	defer ob.mu.Unlock()
+// Publish makes the book's current state its View. The writer calls it once
+// a command is done, so readers never see one half applied.
+func (ob *Orderbook) Publish() {
+	ob.view.Store(&View{
+		Symbol:         ob.Symbol,
+		Scale:          ob.Scale,
+		Sequence:       ob.Sequence,
+		LastTradePrice: ob.LastTradePrice,
+		Bids:           ob.Bids.publish(),
+		Asks:           ob.Asks.publish(),
+		Orders:         len(ob.Orders),
+		Triggers:       ob.Triggers.Len(),
+		triggers:       ob.Triggers.publish(),
+	})
+}
 
+// View returns the last published state of the book. It is safe to call
+// from any goroutine.
+func (ob *Orderbook) View() *View {
+	return ob.view.Load()
+}
+
+func (ob *Orderbook) AddOrder(order *Order) {
 	ob.Orders[order.ID] = order
-	ob.Sequence++
+
+	orders, exists := ob.byUser[order.UserID]
+	if !exists {
+		orders = make(map[string]*Order)
+		ob.byUser[order.UserID] = orders
+	}
+	orders[order.ID] = order
 
 	side := ob.Asks
 	if order.Side == Buy {
@@ -40,16 +81,18 @@ func (ob *Orderbook) AddOrder(order *Order) {
 }
 
 func (ob *Orderbook) RemoveOrder(orderID string) *Order {
-	ob.mu.Lock()
-	defer ob.mu.Unlock()
-
 	order, exists := ob.Orders[orderID]
 	if !exists {
 		return nil
 	}
 
 	delete(ob.Orders, orderID)
-	ob.Sequence++
+
+	if orders := ob.byUser[order.UserID]; len(orders) > 1 {
+		delete(orders, orderID)
+	} else {
+		delete(ob.byUser, order.UserID)
+	}
 
 	side := ob.Asks
 	if order.Side == Buy {
@@ -60,69 +103,145 @@ func (ob *Orderbook) RemoveOrder(orderID string) *Order {
 	return order
 }
 
+// ReduceOrder lowers a resting order's total quantity to quantity, which
+// must be more than it has filled, without moving it in its level.
+func (ob *Orderbook) ReduceOrder(order *Order, quantity int64) {
+	side := ob.Asks
+	if order.Side == Buy {
+		side = ob.Bids
+	}
+	level := side.GetLevel(order.Price)
+	if level == nil {
+		return
+	}
+
+	// An iceberg gives up its hidden reserve before its visible slice.
+	visible := order.Visible()
+	order.RemainingQty -= order.Quantity - quantity
+	order.Quantity = quantity
+	if order.IsIceberg() && order.VisibleQty > order.RemainingQty {
+		order.VisibleQty = order.RemainingQty
+	}
+	level.UpdateVolume(order.Visible() - visible)
+}
+
+// Replenish shows the next slice of a resting iceberg whose visible
+// quantity is used up and moves it to the back of its level.
+func (ob *Orderbook) Replenish(order *Order) {
+	side := ob.Asks
+	if order.Side == Buy {
+		side = ob.Bids
+	}
+	level := side.GetLevel(order.Price)
+	if level == nil || level.RemoveOrder(order.ID) == nil {
+		return
+	}
+
+	order.Refresh()
+	level.AddOrder(order)
+}
+
 func (ob *Orderbook) GetOrder(orderID string) *Order {
-	ob.mu.RLock()
-	defer ob.mu.RUnlock()
 	return ob.Orders[orderID]
 }
 
+// UserOrders returns the user's resting orders, oldest first.
+func (ob *Orderbook) UserOrders(userID string) []*Order {
+	orders := make([]*Order, 0, len(ob.byUser[userID]))
+	for _, order := range ob.byUser[userID] {
+		orders = append(orders, order)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].Timestamp.Equal(orders[j].Timestamp) {
+			return orders[i].Timestamp.Before(orders[j].Timestamp)
+		}
+		return orders[i].ID < orders[j].ID
+	})
+	return orders
+}
+
 func (ob *Orderbook) BestBid() *PriceLevel {
-	ob.mu.RLock()
-	defer ob.mu.RUnlock()
 	return ob.Bids.Best()
 }
 
 func (ob *Orderbook) BestAsk() *PriceLevel {
-	ob.mu.RLock()
-	defer ob.mu.RUnlock()
 	return ob.Asks.Best()
 }
 
-func (ob *Orderbook) GetDepth(limit int) (bids, asks [][2]string) {
-	ob.mu.RLock()
-	defer ob.mu.RUnlock()
-
+func (ob *Orderbook) GetDepth(limit int) (bids, asks [][2]int64) {
 	bids = ob.Bids.GetLevels(limit)
 	asks = ob.Asks.GetLevels(limit)
 	return
 }
 
 func (ob *Orderbook) GetSequence() uint64 {
-	ob.mu.RLock()
-	defer ob.mu.RUnlock()
 	return ob.Sequence
 }
 
+// NextSequence numbers the next update published for the book. Sequence
+// only moves here, by one per update, so a consumer that sees prev differ
+// from the last sequence it applied knows it missed one.
+func (ob *Orderbook) NextSequence() (prev, next uint64) {
+	ob.Sequence++
+	return ob.Sequence - 1, ob.Sequence
+}
+
 type BookSide struct {
-	levels    map[string]*PriceLevel
-	sorted    []decimal.Decimal
+	levels    map[int64]*PriceLevel
+	prices    *priceTree
 	isDescend bool
+
+	// byStopPrice keys levels by StopPrice instead of Price, for triggers.
+	byStopPrice bool
+
+	// publishes is set on the sides of an Orderbook, whose levels record
+	// when they change so that publish only copies those.
+	publishes bool
+	changed   []*PriceLevel
+	views     LevelViews
 }
 
 func NewBookSide(isDescend bool) *BookSide {
+	less := func(a, b int64) bool { return a < b }
+	if isDescend {
+		less = func(a, b int64) bool { return a > b }
+	}
+
 	return &BookSide{
-		levels:    make(map[string]*PriceLevel),
-		sorted:    make([]decimal.Decimal, 0),
+		levels:    make(map[int64]*PriceLevel),
+		prices:    newPriceTree(less),
 		isDescend: isDescend,
 	}
 }
 
+func newPublishedSide(isDescend bool) *BookSide {
+	bs := NewBookSide(isDescend)
+	bs.publishes = true
+	return bs
+}
+
 func (bs *BookSide) AddOrder(order *Order) {
-	priceStr := order.Price.String()
-	level, exists := bs.levels[priceStr]
+	price := order.Price
+	if bs.byStopPrice {
+		price = order.StopPrice
+	}
+
+	level, exists := bs.levels[price]
 
 	if !exists {
-		level = NewPriceLevel(order.Price)
-		bs.levels[priceStr] = level
-		bs.insertPrice(order.Price)
+		level = NewPriceLevel(price)
+		if bs.publishes {
+			level.side = bs
+		}
+		bs.levels[price] = level
+		bs.prices.Insert(price, level)
 	}
 
 	level.AddOrder(order)
 }
 
-func (bs *BookSide) RemoveOrder(price decimal.Decimal, orderID string) *Order {
-	priceStr := price.String()
-	level, exists := bs.levels[priceStr]
+func (bs *BookSide) RemoveOrder(price int64, orderID string) *Order {
+	level, exists := bs.levels[price]
 	if !exists {
 		return nil
 	}
@@ -130,70 +249,59 @@ func (bs *BookSide) RemoveOrder(price decimal.Decimal, orderID string) *Order {
 	order := level.RemoveOrder(orderID)
 
 	if level.IsEmpty() {
-		delete(bs.levels, priceStr)
-		bs.removePrice(price)
+		delete(bs.levels, price)
+		bs.prices.Delete(price)
 	}
 
 	return order
 }
 
 func (bs *BookSide) Best() *PriceLevel {
-	if len(bs.sorted) == 0 {
-		return nil
-	}
-	return bs.levels[bs.sorted[0].String()]
+	return bs.prices.First()
 }
 
-func (bs *BookSide) GetLevel(price string) *PriceLevel {
+func (bs *BookSide) GetLevel(price int64) *PriceLevel {
 	return bs.levels[price]
 }
 
-func (bs *BookSide) GetLevels(limit int) [][2]string {
-	result := make([][2]string, 0, min(limit, len(bs.sorted)))
+func (bs *BookSide) GetLevels(limit int) [][2]int64 {
+	result := make([][2]int64, 0, min(limit, bs.prices.Len()))
 
-	for i := 0; i < len(bs.sorted) && i < limit; i++ {
-		price := bs.sorted[i]
-		level := bs.levels[price.String()]
-		result = append(result, [2]string{price.String(), level.Volume.String()})
-	}
+	bs.Walk(func(level *PriceLevel) bool {
+		if len(result) >= limit {
+			return false
+		}
+		result = append(result, [2]int64{level.Price, level.Volume})
+		return true
+	})
 
 	return result
 }
 
-func (bs *BookSide) insertPrice(price decimal.Decimal) {
-	idx := bs.findInsertIndex(price)
-	bs.sorted = append(bs.sorted, decimal.Zero)
-	copy(bs.sorted[idx+1:], bs.sorted[idx:])
-	bs.sorted[idx] = price
+// Walk visits levels from the best price outwards until fn returns false.
+func (bs *BookSide) Walk(fn func(level *PriceLevel) bool) {
+	bs.prices.Walk(fn)
 }
 
-func (bs *BookSide) removePrice(price decimal.Decimal) {
-	for i, p := range bs.sorted {
-		if p.Equal(price) {
-			bs.sorted = append(bs.sorted[:i], bs.sorted[i+1:]...)
-			return
+// WalkOrders visits orders from the best price outwards, FIFO within a
+// level, until fn returns false. It reports whether the walk ran to the end.
+func (bs *BookSide) WalkOrders(fn func(order *Order) bool) bool {
+	more := true
+	bs.Walk(func(level *PriceLevel) bool {
+		for e := level.Orders.Front(); e != nil && more; e = e.Next() {
+			more = fn(e.Value.(*Order))
 		}
-	}
+		return more
+	})
+	return more
 }
 
-func (bs *BookSide) findInsertIndex(price decimal.Decimal) int {
-	for i, p := range bs.sorted {
-		if bs.isDescend {
-			if price.GreaterThan(p) {
-				return i
-			}
-		} else {
-			if price.LessThan(p) {
-				return i
-			}
-		}
-	}
-	return len(bs.sorted)
+func (bs *BookSide) Len() int {
+	return bs.prices.Len()
 }
 
-func (bs *BookSide) UpdateLevelVolume(price decimal.Decimal, delta decimal.Decimal) {
-	priceStr := price.String()
-	if level, exists := bs.levels[priceStr]; exists {
+func (bs *BookSide) UpdateLevelVolume(price int64, delta int64) {
+	if level, exists := bs.levels[price]; exists {
 		level.UpdateVolume(delta)
 	}
 }
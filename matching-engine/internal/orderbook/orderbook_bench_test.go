@@ -0,0 +1,69 @@
+package orderbook
+
+import (
+	"fmt"
+	"testing"
+)
+
+var benchDepths = []int{100, 10000, 50000}
+
+// deepBookSide returns an ask side holding depth levels 10 ticks apart, from
+// 10 to depth*10, with one order each.
+func deepBookSide(depth int) *BookSide {
+	bs := NewBookSide(false)
+	for i := 1; i <= depth; i++ {
+		bs.AddOrder(NewOrder(fmt.Sprintf("o%d", i), "u", "BTC/USDT", Sell, Limit, int64(i*10), 1))
+	}
+	return bs
+}
+
+func BenchmarkBookSideInsertRemoveMid(b *testing.B) {
+	for _, depth := range benchDepths {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			bs := deepBookSide(depth)
+			price := int64(depth/2*10 + 5)
+			order := NewOrder("mid", "u", "BTC/USDT", Sell, Limit, price, 1)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bs.AddOrder(order)
+				bs.RemoveOrder(price, order.ID)
+			}
+		})
+	}
+}
+
+func BenchmarkBookSideInsertRemoveWorst(b *testing.B) {
+	for _, depth := range benchDepths {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			bs := deepBookSide(depth)
+			price := int64((depth + 1) * 10)
+			order := NewOrder("worst", "u", "BTC/USDT", Sell, Limit, price, 1)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bs.AddOrder(order)
+				bs.RemoveOrder(price, order.ID)
+			}
+		})
+	}
+}
+
+func BenchmarkBookSideConsumeBest(b *testing.B) {
+	for _, depth := range benchDepths {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			bs := deepBookSide(depth)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				best := bs.Best()
+				order := best.Front()
+				bs.RemoveOrder(best.Price, order.ID)
+				bs.AddOrder(order)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package orderbook
+
+// priceTree is a red-black tree of price levels keyed by ticks. Keys are
+// ordered by less, so the minimum is always the best price of the side that
+// owns the tree.
+type priceTree struct {
+	root     *treeNode
+	sentinel *treeNode
+	min      *treeNode
+	size     int
+	less     func(a, b int64) bool
+}
+
+type treeNode struct {
+	price  int64
+	level  *PriceLevel
+	left   *treeNode
+	right  *treeNode
+	parent *treeNode
+	red    bool
+}
+
+func newPriceTree(less func(a, b int64) bool) *priceTree {
+	sentinel := &treeNode{}
+	sentinel.left, sentinel.right, sentinel.parent = sentinel, sentinel, sentinel
+	return &priceTree{
+		root:     sentinel,
+		sentinel: sentinel,
+		min:      sentinel,
+		less:     less,
+	}
+}
+
+func (t *priceTree) Len() int {
+	return t.size
+}
+
+func (t *priceTree) First() *PriceLevel {
+	if t.min == t.sentinel {
+		return nil
+	}
+	return t.min.level
+}
+
+// Insert adds level under price. The caller guarantees price is not present.
+func (t *priceTree) Insert(price int64, level *PriceLevel) {
+	z := &treeNode{price: price, level: level, left: t.sentinel, right: t.sentinel, red: true}
+
+	y := t.sentinel
+	x := t.root
+	for x != t.sentinel {
+		y = x
+		if t.less(price, x.price) {
+			x = x.left
+		} else {
+			x = x.right
+		}
+	}
+
+	z.parent = y
+	switch {
+	case y == t.sentinel:
+		t.root = z
+	case t.less(price, y.price):
+		y.left = z
+	default:
+		y.right = z
+	}
+
+	if t.min == t.sentinel || t.less(price, t.min.price) {
+		t.min = z
+	}
+	t.size++
+
+	t.insertFixup(z)
+}
+
+func (t *priceTree) Delete(price int64) {
+	z := t.find(price)
+	if z == t.sentinel {
+		return
+	}
+
+	if z == t.min {
+		t.min = t.successor(z)
+	}
+
+	y := z
+	yRed := y.red
+	var x *treeNode
+
+	switch {
+	case z.left == t.sentinel:
+		x = z.right
+		t.transplant(z, z.right)
+	case z.right == t.sentinel:
+		x = z.left
+		t.transplant(z, z.left)
+	default:
+		y = t.minimum(z.right)
+		yRed = y.red
+		x = y.right
+		if y.parent == z {
+			x.parent = y
+		} else {
+			t.transplant(y, y.right)
+			y.right = z.right
+			y.right.parent = y
+		}
+		t.transplant(z, y)
+		y.left = z.left
+		y.left.parent = y
+		y.red = z.red
+	}
+
+	if !yRed {
+		t.deleteFixup(x)
+	}
+	t.size--
+}
+
+// Walk visits levels in key order until fn returns false.
+func (t *priceTree) Walk(fn func(level *PriceLevel) bool) {
+	for n := t.min; n != t.sentinel; n = t.successor(n) {
+		if !fn(n.level) {
+			return
+		}
+	}
+}
+
+func (t *priceTree) find(price int64) *treeNode {
+	x := t.root
+	for x != t.sentinel {
+		switch {
+		case t.less(price, x.price):
+			x = x.left
+		case t.less(x.price, price):
+			x = x.right
+		default:
+			return x
+		}
+	}
+	return t.sentinel
+}
+
+func (t *priceTree) minimum(x *treeNode) *treeNode {
+	for x.left != t.sentinel {
+		x = x.left
+	}
+	return x
+}
+
+func (t *priceTree) successor(x *treeNode) *treeNode {
+	if x.right != t.sentinel {
+		return t.minimum(x.right)
+	}
+	y := x.parent
+	for y != t.sentinel && x == y.right {
+		x = y
+		y = y.parent
+	}
+	return y
+}
+
+func (t *priceTree) rotateLeft(x *treeNode) {
+	y := x.right
+	x.right = y.left
+	if y.left != t.sentinel {
+		y.left.parent = x
+	}
+	y.parent = x.parent
+	switch {
+	case x.parent == t.sentinel:
+		t.root = y
+	case x == x.parent.left:
+		x.parent.left = y
+	default:
+		x.parent.right = y
+	}
+	y.left = x
+	x.parent = y
+}
+
+func (t *priceTree) rotateRight(x *treeNode) {
+	y := x.left
+	x.left = y.right
+	if y.right != t.sentinel {
+		y.right.parent = x
+	}
+	y.parent = x.parent
+	switch {
+	case x.parent == t.sentinel:
+		t.root = y
+	case x == x.parent.right:
+		x.parent.right = y
+	default:
+		x.parent.left = y
+	}
+	y.right = x
+	x.parent = y
+}
+
+func (t *priceTree) insertFixup(z *treeNode) {
+	for z.parent.red {
+		if z.parent == z.parent.parent.left {
+			y := z.parent.parent.right
+			if y.red {
+				z.parent.red = false
+				y.red = false
+				z.parent.parent.red = true
+				z = z.parent.parent
+				continue
+			}
+			if z == z.parent.right {
+				z = z.parent
+				t.rotateLeft(z)
+			}
+			z.parent.red = false
+			z.parent.parent.red = true
+			t.rotateRight(z.parent.parent)
+		} else {
+			y := z.parent.parent.left
+			if y.red {
+				z.parent.red = false
+				y.red = false
+				z.parent.parent.red = true
+				z = z.parent.parent
+				continue
+			}
+			if z == z.parent.left {
+				z = z.parent
+				t.rotateRight(z)
+			}
+			z.parent.red = false
+			z.parent.parent.red = true
+			t.rotateLeft(z.parent.parent)
+		}
+	}
+	t.root.red = false
+}
+
+func (t *priceTree) transplant(u, v *treeNode) {
+	switch {
+	case u.parent == t.sentinel:
+		t.root = v
+	case u == u.parent.left:
+		u.parent.left = v
+	default:
+		u.parent.right = v
+	}
+	v.parent = u.parent
+}
+
+func (t *priceTree) deleteFixup(x *treeNode) {
+	for x != t.root && !x.red {
+		if x == x.parent.left {
+			w := x.parent.right
+			if w.red {
+				w.red = false
+				x.parent.red = true
+				t.rotateLeft(x.parent)
+				w = x.parent.right
+			}
+			if !w.left.red && !w.right.red {
+				w.red = true
+				x = x.parent
+				continue
+			}
+			if !w.right.red {
+				w.left.red = false
+				w.red = true
+				t.rotateRight(w)
+				w = x.parent.right
+			}
+			w.red = x.parent.red
+			x.parent.red = false
+			w.right.red = false
+			t.rotateLeft(x.parent)
+			x = t.root
+		} else {
+			w := x.parent.left
+			if w.red {
+				w.red = false
+				x.parent.red = true
+				t.rotateRight(x.parent)
+				w = x.parent.left
+			}
+			if !w.right.red && !w.left.red {
+				w.red = true
+				x = x.parent
+				continue
+			}
+			if !w.left.red {
+				w.right.red = false
+				w.red = true
+				t.rotateLeft(w)
+				w = x.parent.left
+			}
+			w.red = x.parent.red
+			x.parent.red = false
+			w.left.red = false
+			t.rotateRight(x.parent)
+			x = t.root
+		}
+	}
+	x.red = false
+}
@@ -0,0 +1,183 @@
+package orderbook
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+var (
+	askLess = func(a, b int64) bool { return a < b }
+	bidLess = func(a, b int64) bool { return a > b }
+)
+
+// checkTree fails unless t is a valid red-black tree holding exactly want,
+// in order, with its cached minimum at the front.
+func checkTree(t *testing.T, tree *priceTree, want []int64) {
+	t.Helper()
+
+	if tree.root.red {
+		t.Fatal("root is red")
+	}
+	if tree.sentinel.red {
+		t.Fatal("sentinel is red")
+	}
+
+	var blackHeight func(n *treeNode) int
+	blackHeight = func(n *treeNode) int {
+		if n == tree.sentinel {
+			return 1
+		}
+		if n.red && (n.left.red || n.right.red) {
+			t.Fatalf("red node %d has a red child", n.price)
+		}
+		if n.left != tree.sentinel && (n.left.parent != n || !tree.less(n.left.price, n.price)) {
+			t.Fatalf("bad left child %d of %d", n.left.price, n.price)
+		}
+		if n.right != tree.sentinel && (n.right.parent != n || !tree.less(n.price, n.right.price)) {
+			t.Fatalf("bad right child %d of %d", n.right.price, n.price)
+		}
+		left, right := blackHeight(n.left), blackHeight(n.right)
+		if left != right {
+			t.Fatalf("node %d has black heights %d and %d", n.price, left, right)
+		}
+		if n.red {
+			return left
+		}
+		return left + 1
+	}
+	blackHeight(tree.root)
+
+	if tree.Len() != len(want) {
+		t.Fatalf("Len() = %d, want %d", tree.Len(), len(want))
+	}
+	var got []int64
+	tree.Walk(func(level *PriceLevel) bool {
+		got = append(got, level.Price)
+		return true
+	})
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Walk gave %v, want %v", got, want)
+	}
+
+	first := tree.First()
+	switch {
+	case len(want) == 0 && first != nil:
+		t.Fatalf("First() = %d in an empty tree", first.Price)
+	case len(want) > 0 && (first == nil || first.Price != want[0]):
+		t.Fatalf("First() = %v, want %d", first, want[0])
+	}
+}
+
+func insertPrice(tree *priceTree, price int64) {
+	tree.Insert(price, NewPriceLevel(price))
+}
+
+func TestPriceTreeMatchesSortedSlice(t *testing.T) {
+	for _, side := range []struct {
+		name string
+		less func(a, b int64) bool
+	}{{"asks", askLess}, {"bids", bidLess}} {
+		t.Run(side.name, func(t *testing.T) {
+			for seed := int64(1); seed <= 20; seed++ {
+				rng := rand.New(rand.NewSource(seed))
+				tree := newPriceTree(side.less)
+				present := make(map[int64]bool)
+				var oracle []int64
+
+				for i := 0; i < 2000; i++ {
+					price := int64(rng.Intn(300))
+					if present[price] {
+						tree.Delete(price)
+						delete(present, price)
+						idx := sort.Search(len(oracle), func(j int) bool { return !side.less(oracle[j], price) })
+						oracle = append(oracle[:idx], oracle[idx+1:]...)
+					} else {
+						insertPrice(tree, price)
+						present[price] = true
+						idx := sort.Search(len(oracle), func(j int) bool { return !side.less(oracle[j], price) })
+						oracle = append(oracle[:idx], append([]int64{price}, oracle[idx:]...)...)
+					}
+					if i%50 == 0 {
+						checkTree(t, tree, oracle)
+					}
+				}
+				checkTree(t, tree, oracle)
+
+				for len(oracle) > 0 {
+					price := oracle[rng.Intn(len(oracle))]
+					tree.Delete(price)
+					for j, p := range oracle {
+						if p == price {
+							oracle = append(oracle[:j], oracle[j+1:]...)
+							break
+						}
+					}
+					checkTree(t, tree, oracle)
+				}
+			}
+		})
+	}
+}
+
+func TestPriceTreeDeleteMinimum(t *testing.T) {
+	tree := newPriceTree(askLess)
+	for _, price := range []int64{50, 20, 80, 10, 30, 70, 90} {
+		insertPrice(tree, price)
+	}
+
+	want := []int64{10, 20, 30, 50, 70, 80, 90}
+	for len(want) > 0 {
+		checkTree(t, tree, want)
+		if tree.min.price != want[0] {
+			t.Fatalf("cached min %d, want %d", tree.min.price, want[0])
+		}
+		tree.Delete(want[0])
+		want = want[1:]
+	}
+	checkTree(t, tree, nil)
+	if tree.min != tree.sentinel {
+		t.Fatal("cached min of an empty tree is not the sentinel")
+	}
+
+	insertPrice(tree, 40)
+	checkTree(t, tree, []int64{40})
+}
+
+func TestPriceTreeWalkOrder(t *testing.T) {
+	prices := []int64{105, 99, 101, 110, 100, 97}
+	for _, tc := range []struct {
+		name string
+		less func(a, b int64) bool
+		want string
+	}{
+		{"asks", askLess, "[97 99 100 101 105 110]"},
+		{"bids", bidLess, "[110 105 101 100 99 97]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tree := newPriceTree(tc.less)
+			for _, price := range prices {
+				insertPrice(tree, price)
+			}
+
+			var all []int64
+			tree.Walk(func(level *PriceLevel) bool {
+				all = append(all, level.Price)
+				return true
+			})
+			if fmt.Sprint(all) != tc.want {
+				t.Fatalf("Walk gave %v, want %s", all, tc.want)
+			}
+
+			var firstThree []int64
+			tree.Walk(func(level *PriceLevel) bool {
+				firstThree = append(firstThree, level.Price)
+				return len(firstThree) < 3
+			})
+			if len(firstThree) != 3 || fmt.Sprint(firstThree) != fmt.Sprint(all[:3]) {
+				t.Fatalf("stopped Walk gave %v, want %v", firstThree, all[:3])
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+
+	"github.com/shopspring/decimal"
+)
+
+// Scale converts between the decimal prices and quantities used on the wire
+// and the int64 ticks and lots the book works with. A market with
+// PriceDecimals 2 stores 101.25 as 10125 ticks.
+type Scale struct {
+	PriceDecimals int32 `json:"priceDecimals"`
+	QtyDecimals   int32 `json:"qtyDecimals"`
+}
+
+var DefaultScale = Scale{PriceDecimals: 8, QtyDecimals: 8}
+
+// ErrPrecision is returned for values finer than a scale can represent.
+var ErrPrecision = errors.New("too many decimal places")
+
+func (s Scale) ToTicks(price decimal.Decimal) (int64, error) {
+	return toFixed(price, s.PriceDecimals)
+}
+
+func (s Scale) ToLots(qty decimal.Decimal) (int64, error) {
+	return toFixed(qty, s.QtyDecimals)
+}
+
+func (s Scale) Price(ticks int64) decimal.Decimal {
+	return decimal.New(ticks, -s.PriceDecimals)
+}
+
+func (s Scale) Qty(lots int64) decimal.Decimal {
+	return decimal.New(lots, -s.QtyDecimals)
+}
+
+func (s Scale) Quote(q Quote) decimal.Decimal {
+	return decimal.NewFromBigInt(q.BigInt(), -(s.PriceDecimals + s.QtyDecimals))
+}
+
+// ToQuote converts a non-negative ticks*lots amount, such as a minimum
+// notional, to a Quote.
+func (s Scale) ToQuote(d decimal.Decimal) (Quote, error) {
+	shifted := d.Shift(s.PriceDecimals + s.QtyDecimals)
+	if !shifted.IsInteger() {
+		return Quote{}, fmt.Errorf("%s has more than %d decimal places: %w", d, s.PriceDecimals+s.QtyDecimals, ErrPrecision)
+	}
+
+	n := shifted.BigInt()
+	if n.Sign() < 0 || n.BitLen() > 128 {
+		return Quote{}, fmt.Errorf("%s is out of range", d)
+	}
+	lo := new(big.Int).And(n, new(big.Int).SetUint64(math.MaxUint64))
+	return Quote{Hi: new(big.Int).Rsh(n, 64).Uint64(), Lo: lo.Uint64()}, nil
+}
+
+// AvgPrice returns quote divided by lots with decimal's default precision.
+func (s Scale) AvgPrice(quote Quote, lots int64) decimal.Decimal {
+	if lots == 0 {
+		return decimal.Zero
+	}
+	return s.Quote(quote).Div(s.Qty(lots))
+}
+
+func toFixed(d decimal.Decimal, decimals int32) (int64, error) {
+	shifted := d.Shift(decimals)
+	if !shifted.IsInteger() {
+		return 0, fmt.Errorf("%s has more than %d decimal places: %w", d, decimals, ErrPrecision)
+	}
+
+	n := shifted.BigInt()
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("%s is out of range", d)
+	}
+	return n.Int64(), nil
+}
+
+// Quote is an exact, non-negative ticks*lots amount. It is kept as 128 bits
+// because the product of two int64 values does not fit in 64.
+type Quote struct {
+	Hi uint64 `json:"hi"`
+	Lo uint64 `json:"lo"`
+}
+
+func MulQuote(ticks, lots int64) Quote {
+	hi, lo := bits.Mul64(uint64(ticks), uint64(lots))
+	return Quote{Hi: hi, Lo: lo}
+}
+
+func (q Quote) Add(o Quote) Quote {
+	lo, carry := bits.Add64(q.Lo, o.Lo, 0)
+	hi, _ := bits.Add64(q.Hi, o.Hi, carry)
+	return Quote{Hi: hi, Lo: lo}
+}
+
+func (q Quote) Sub(o Quote) Quote {
+	lo, borrow := bits.Sub64(q.Lo, o.Lo, 0)
+	hi, _ := bits.Sub64(q.Hi, o.Hi, borrow)
+	return Quote{Hi: hi, Lo: lo}
+}
+
+func (q Quote) Cmp(o Quote) int {
+	switch {
+	case q.Hi < o.Hi, q.Hi == o.Hi && q.Lo < o.Lo:
+		return -1
+	case q == o:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// Div returns q/ticks rounded down, or math.MaxInt64 if that does not fit.
+func (q Quote) Div(ticks int64) int64 {
+	if q.Hi >= uint64(ticks) {
+		return math.MaxInt64
+	}
+	n, _ := bits.Div64(q.Hi, q.Lo, uint64(ticks))
+	if n > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(n)
+}
+
+func (q Quote) IsZero() bool {
+	return q.Hi == 0 && q.Lo == 0
+}
+
+func (q Quote) BigInt() *big.Int {
+	n := new(big.Int).SetUint64(q.Hi)
+	n.Lsh(n, 64)
+	return n.Or(n, new(big.Int).SetUint64(q.Lo))
+}
@@ -0,0 +1,79 @@
+package orderbook
+
+type LevelSnapshot struct {
+	Price  int64    `json:"price"`
+	Orders []*Order `json:"orders"`
+}
+
+// BookSnapshot keeps each level's orders in FIFO order so that restoring it
+// preserves price-time priority.
+type BookSnapshot struct {
+	Symbol         string          `json:"symbol"`
+	Scale          Scale           `json:"scale"`
+	Sequence       uint64          `json:"sequence"`
+	LastTradePrice int64           `json:"lastTradePrice"`
+	Bids           []LevelSnapshot `json:"bids"`
+	Asks           []LevelSnapshot `json:"asks"`
+	Triggers       []*Order        `json:"triggers"`
+}
+
+func (ob *Orderbook) Snapshot() *BookSnapshot {
+	return &BookSnapshot{
+		Symbol:         ob.Symbol,
+		Scale:          ob.Scale,
+		Sequence:       ob.Sequence,
+		LastTradePrice: ob.LastTradePrice,
+		Bids:           ob.Bids.snapshotLevels(),
+		Asks:           ob.Asks.snapshotLevels(),
+		Triggers:       ob.Triggers.snapshot(),
+	}
+}
+
+func RestoreOrderbook(snap *BookSnapshot) *Orderbook {
+	ob := NewOrderbook(snap.Symbol, snap.Scale)
+
+	for _, levels := range [][]LevelSnapshot{snap.Bids, snap.Asks} {
+		for _, level := range levels {
+			for _, o := range level.Orders {
+				order := *o
+				ob.AddOrder(&order)
+			}
+		}
+	}
+
+	for _, o := range snap.Triggers {
+		order := *o
+		ob.Triggers.Add(&order)
+	}
+
+	ob.Sequence = snap.Sequence
+	ob.LastTradePrice = snap.LastTradePrice
+	ob.Publish()
+	return ob
+}
+
+func (bs *BookSide) snapshotLevels() []LevelSnapshot {
+	levels := make([]LevelSnapshot, 0, bs.Len())
+
+	bs.Walk(func(level *PriceLevel) bool {
+		orders := make([]*Order, 0, level.Len())
+		for e := level.Orders.Front(); e != nil; e = e.Next() {
+			order := *e.Value.(*Order)
+			orders = append(orders, &order)
+		}
+		levels = append(levels, LevelSnapshot{Price: level.Price, Orders: orders})
+		return true
+	})
+
+	return levels
+}
+
+func (tb *TriggerBook) snapshot() []*Order {
+	orders := make([]*Order, 0, tb.Len())
+	tb.Walk(func(o *Order) bool {
+		order := *o
+		orders = append(orders, &order)
+		return true
+	})
+	return orders
+}
@@ -0,0 +1,86 @@
+package orderbook
+
+// TriggerBook holds conditional orders until the last trade price reaches
+// their stop price. Orders that fire on a rise are kept lowest stop first,
+// those that fire on a fall highest stop first, each FIFO within a price.
+type TriggerBook struct {
+	rising  *BookSide
+	falling *BookSide
+	orders  map[string]*Order
+}
+
+func NewTriggerBook() *TriggerBook {
+	rising := newPublishedSide(false)
+	rising.byStopPrice = true
+	falling := newPublishedSide(true)
+	falling.byStopPrice = true
+
+	return &TriggerBook{
+		rising:  rising,
+		falling: falling,
+		orders:  make(map[string]*Order),
+	}
+}
+
+func (tb *TriggerBook) Add(order *Order) {
+	tb.orders[order.ID] = order
+	tb.side(order).AddOrder(order)
+}
+
+func (tb *TriggerBook) Remove(orderID string) *Order {
+	order, exists := tb.orders[orderID]
+	if !exists {
+		return nil
+	}
+
+	delete(tb.orders, orderID)
+	tb.side(order).RemoveOrder(order.StopPrice, orderID)
+	return order
+}
+
+func (tb *TriggerBook) Get(orderID string) *Order {
+	return tb.orders[orderID]
+}
+
+func (tb *TriggerBook) Len() int {
+	return len(tb.orders)
+}
+
+// Next removes and returns the next order lastPrice triggers, or nil if there
+// is none. Orders firing on a rise go before those firing on a fall.
+func (tb *TriggerBook) Next(lastPrice int64) *Order {
+	if lastPrice == 0 {
+		return nil
+	}
+
+	for _, side := range []*BookSide{tb.rising, tb.falling} {
+		level := side.Best()
+		if level == nil {
+			continue
+		}
+		if order := level.Front(); order.StopReached(lastPrice) {
+			return tb.Remove(order.ID)
+		}
+	}
+	return nil
+}
+
+// Walk visits every order in trigger order until fn returns false.
+func (tb *TriggerBook) Walk(fn func(order *Order) bool) {
+	if tb.rising.WalkOrders(fn) {
+		tb.falling.WalkOrders(fn)
+	}
+}
+
+func (tb *TriggerBook) side(order *Order) *BookSide {
+	if order.TriggersOnRise() {
+		return tb.rising
+	}
+	return tb.falling
+}
+
+// publish brings the views of both sides up to date, rising first. Their
+// levels are keyed by stop price.
+func (tb *TriggerBook) publish() [2]LevelViews {
+	return [2]LevelViews{tb.rising.publish(), tb.falling.publish()}
+}
@@ -0,0 +1,218 @@
+package orderbook
+
+// View is an immutable copy of a book as of one sequence number. The book's
+// writer publishes a new one after each command and any goroutine may read
+// it without locks. Nothing reachable from a View may be modified.
+type View struct {
+	Symbol         string
+	Scale          Scale
+	Sequence       uint64
+	LastTradePrice int64
+	Bids           LevelViews
+	Asks           LevelViews
+
+	// Orders counts resting orders and Triggers those waiting for their
+	// stop price.
+	Orders   int
+	Triggers int
+
+	// triggers are the trigger book's rising and falling sides.
+	triggers [2]LevelViews
+}
+
+// LevelView is a price level with copies of its orders in FIFO order.
+type LevelView struct {
+	Price  int64
+	Volume int64
+	Orders []Order
+}
+
+// Depth returns up to limit levels of each side, best first, as
+// [price, volume] pairs.
+func (v *View) Depth(limit int) (bids, asks [][2]int64) {
+	return v.Bids.depth(limit), v.Asks.depth(limit)
+}
+
+// Order looks up a resting order by walking the levels.
+func (v *View) Order(orderID string) (*Order, bool) {
+	return findOrder([]LevelViews{v.Bids, v.Asks}, orderID)
+}
+
+// Trigger looks up a conditional order waiting for its stop price.
+func (v *View) Trigger(orderID string) (*Order, bool) {
+	return findOrder(v.triggers[:], orderID)
+}
+
+func findOrder(sides []LevelViews, orderID string) (*Order, bool) {
+	for _, side := range sides {
+		var found *Order
+		side.Walk(func(level *LevelView) bool {
+			for i := range level.Orders {
+				if level.Orders[i].ID == orderID {
+					found = &level.Orders[i]
+					return false
+				}
+			}
+			return true
+		})
+		if found != nil {
+			return found, true
+		}
+	}
+	return nil, false
+}
+
+// LevelViews is one side of a View, ordered best price first. Publishing a
+// new View copies only the levels that changed and the path to them, so
+// consecutive views share everything else.
+type LevelViews struct {
+	root *viewNode
+	size int
+}
+
+type viewNode struct {
+	level    *LevelView
+	priority uint64
+	left     *viewNode
+	right    *viewNode
+}
+
+func (lv LevelViews) Len() int {
+	return lv.size
+}
+
+func (lv LevelViews) Best() *LevelView {
+	n := lv.root
+	if n == nil {
+		return nil
+	}
+	for n.left != nil {
+		n = n.left
+	}
+	return n.level
+}
+
+// Walk visits levels from the best price outwards until fn returns false.
+func (lv LevelViews) Walk(fn func(level *LevelView) bool) {
+	lv.root.walk(fn)
+}
+
+func (lv LevelViews) depth(limit int) [][2]int64 {
+	levels := make([][2]int64, 0, min(limit, lv.size))
+	lv.Walk(func(level *LevelView) bool {
+		if len(levels) >= limit {
+			return false
+		}
+		levels = append(levels, [2]int64{level.Price, level.Volume})
+		return true
+	})
+	return levels
+}
+
+func (n *viewNode) walk(fn func(level *LevelView) bool) bool {
+	if n == nil {
+		return true
+	}
+	return n.left.walk(fn) && fn(n.level) && n.right.walk(fn)
+}
+
+// put returns a tree holding level in place of any level at its price. The
+// tree is a treap whose priorities are a hash of the price, so its shape
+// depends only on the prices it holds. Only nodes on the path to level are
+// copied, which leaves n itself untouched.
+func (n *viewNode) put(level *LevelView, less func(a, b int64) bool) *viewNode {
+	if n == nil {
+		return &viewNode{level: level, priority: priceHash(level.Price)}
+	}
+
+	c := *n
+	switch {
+	case less(level.Price, n.level.Price):
+		c.left = n.left.put(level, less)
+		if c.left.priority > c.priority {
+			l := c.left
+			c.left, l.right = l.right, &c
+			return l
+		}
+	case less(n.level.Price, level.Price):
+		c.right = n.right.put(level, less)
+		if c.right.priority > c.priority {
+			r := c.right
+			c.right, r.left = r.left, &c
+			return r
+		}
+	default:
+		c.level = level
+	}
+	return &c
+}
+
+func (n *viewNode) remove(price int64, less func(a, b int64) bool) *viewNode {
+	if n == nil {
+		return nil
+	}
+
+	c := *n
+	switch {
+	case less(price, n.level.Price):
+		c.left = n.left.remove(price, less)
+	case less(n.level.Price, price):
+		c.right = n.right.remove(price, less)
+	default:
+		return joinViews(n.left, n.right)
+	}
+	return &c
+}
+
+// joinViews merges two trees whose prices in a all come before those in b.
+func joinViews(a, b *viewNode) *viewNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		c := *a
+		c.right = joinViews(a.right, b)
+		return &c
+	}
+	c := *b
+	c.left = joinViews(a, b.left)
+	return &c
+}
+
+// priceHash is splitmix64's finalizer.
+func priceHash(price int64) uint64 {
+	x := uint64(price) + 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// publish brings the side's views up to date with the levels changed since
+// the last call.
+func (bs *BookSide) publish() LevelViews {
+	less := bs.prices.less
+	for i, level := range bs.changed {
+		level.changed = false
+		switch live := bs.levels[level.Price]; {
+		case live == nil:
+			bs.views.root = bs.views.root.remove(level.Price, less)
+		case live == level:
+			bs.views.root = bs.views.root.put(level.view(), less)
+		}
+		bs.changed[i] = nil
+	}
+	bs.changed = bs.changed[:0]
+	bs.views.size = bs.Len()
+	return bs.views
+}
+
+func (pl *PriceLevel) view() *LevelView {
+	orders := make([]Order, 0, pl.Len())
+	for e := pl.Orders.Front(); e != nil; e = e.Next() {
+		orders = append(orders, *e.Value.(*Order))
+	}
+	return &LevelView{Price: pl.Price, Volume: pl.Volume, Orders: orders}
+}
@@ -0,0 +1,105 @@
+package orderbook
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestViewIsUnaffectedByLaterWrites(t *testing.T) {
+	ob := NewOrderbook("BTC/USDT", DefaultScale)
+	ob.AddOrder(NewOrder("b1", "u", "BTC/USDT", Buy, Limit, 100, 5))
+	ob.AddOrder(NewOrder("a1", "u", "BTC/USDT", Sell, Limit, 110, 3))
+	ob.Publish()
+	before := ob.View()
+
+	ob.GetOrder("b1").Fill(2, 100)
+	ob.Bids.GetLevel(100).UpdateVolume(-2)
+	ob.RemoveOrder("a1")
+	ob.AddOrder(NewOrder("b2", "u", "BTC/USDT", Buy, Limit, 99, 1))
+	ob.Publish()
+	after := ob.View()
+
+	bids, asks := before.Depth(10)
+	if fmt.Sprint(bids, asks) != "[[100 5]] [[110 3]]" {
+		t.Fatalf("old view changed: bids %v asks %v", bids, asks)
+	}
+	if order, ok := before.Order("b1"); !ok || order.RemainingQty != 5 {
+		t.Fatalf("old view order b1 = %+v, %v", order, ok)
+	}
+
+	bids, asks = after.Depth(10)
+	if fmt.Sprint(bids, asks) != "[[100 3] [99 1]] []" {
+		t.Fatalf("new view: bids %v asks %v", bids, asks)
+	}
+	if after.Sequence != ob.Sequence || after.Orders != 2 {
+		t.Fatalf("new view sequence %d orders %d, want %d and 2", after.Sequence, after.Orders, ob.Sequence)
+	}
+}
+
+func TestPublishCopiesOnlyChangedLevels(t *testing.T) {
+	ob := NewOrderbook("BTC/USDT", DefaultScale)
+	for i := 1; i <= 100; i++ {
+		ob.AddOrder(NewOrder(fmt.Sprintf("a%d", i), "u", "BTC/USDT", Sell, Limit, int64(100+i), 1))
+	}
+	ob.Publish()
+	before := levelsByPrice(ob.View().Asks)
+
+	ob.AddOrder(NewOrder("x", "u", "BTC/USDT", Sell, Limit, 150, 1))
+	ob.RemoveOrder("a10")
+	ob.Publish()
+	after := levelsByPrice(ob.View().Asks)
+
+	if len(after) != 99 || after[110] != nil {
+		t.Fatalf("got %d levels, level 110 %v; want 99 and none", len(after), after[110])
+	}
+	for price, level := range after {
+		if price == 150 {
+			if level == before[price] || level.Volume != 2 {
+				t.Fatalf("level 150 not republished: %+v", level)
+			}
+		} else if level != before[price] {
+			t.Fatalf("unchanged level %d was copied", price)
+		}
+	}
+}
+
+func TestViewWalksBestFirst(t *testing.T) {
+	ob := NewOrderbook("BTC/USDT", DefaultScale)
+	// Enough levels, added out of order, to give the tree some depth.
+	for i := 0; i < 500; i++ {
+		price := int64(1 + (i*7919)%500)
+		ob.AddOrder(NewOrder(fmt.Sprintf("b%d", i), "u", "BTC/USDT", Buy, Limit, price, 1))
+		ob.AddOrder(NewOrder(fmt.Sprintf("a%d", i), "u", "BTC/USDT", Sell, Limit, price+1000, 1))
+		if i%50 == 0 {
+			ob.Publish()
+		}
+	}
+	for i := 0; i < 500; i += 3 {
+		ob.RemoveOrder(fmt.Sprintf("b%d", i))
+	}
+	ob.Publish()
+	view := ob.View()
+
+	for _, side := range []struct {
+		live  *BookSide
+		views LevelViews
+	}{{ob.Bids, view.Bids}, {ob.Asks, view.Asks}} {
+		want := side.live.GetLevels(side.live.Len())
+		got := side.views.depth(side.views.Len())
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("view levels %v, want %v", got, want)
+		}
+		if best := side.views.Best(); best.Price != side.live.Best().Price {
+			t.Fatalf("best %d, want %d", best.Price, side.live.Best().Price)
+		}
+	}
+}
+
+func levelsByPrice(side LevelViews) map[int64]*LevelView {
+	levels := make(map[int64]*LevelView)
+	side.Walk(func(level *LevelView) bool {
+		levels[level.Price] = level
+		return true
+	})
+	return levels
+}
@@ -0,0 +1,191 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+	"go.uber.org/zap"
+)
+
+const (
+	filePrefix = "snapshot-"
+	fileSuffix = ".json"
+
+	// formatVersion is bumped whenever the encoding of a snapshot or a book
+	// changes, so that a snapshot missing state is rejected rather than
+	// restored without it.
+	//
+	//	1 ticks and lots
+	//	2 command IDs
+	//	3 quote quantities
+	//	4 last trade price and max slippage
+	//	5 trigger book and stop prices
+	//	6 iceberg display and visible quantities
+	//	7 heartbeat clock and deadlines
+	//	8 trade IDs
+	formatVersion = 8
+)
+
+// Snapshot holds every book as of the offsets it covers. Offsets maps an orders
+// partition to the next offset to consume after the snapshot is restored.
+// CommandIDs are the most recently processed command IDs, oldest first.
+// Clock is the engine time taken from command timestamps and Heartbeats the
+// armed heartbeat deadline of each user. TradeIDs is the number of the last
+// trade of each symbol.
+type Snapshot struct {
+	Version    int                       `json:"version"`
+	ID         uint64                    `json:"id"`
+	Offsets    map[int]int64             `json:"offsets"`
+	Books      []*orderbook.BookSnapshot `json:"books"`
+	CommandIDs []string                  `json:"commandIds"`
+	Clock      int64                     `json:"clock"`
+	Heartbeats map[string]int64          `json:"heartbeats"`
+	TradeIDs   map[string]uint64         `json:"tradeIds"`
+	CreatedAt  int64                     `json:"createdAt"`
+}
+
+type Store struct {
+	dir    string
+	retain int
+	lastID uint64
+	logger *zap.Logger
+}
+
+func NewStore(dir string, retain int, logger *zap.Logger) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &Store{dir: dir, retain: retain, logger: logger}
+
+	ids, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		s.lastID = ids[len(ids)-1]
+	}
+
+	return s, nil
+}
+
+func (s *Store) Save(snap *Snapshot) error {
+	snap.Version = formatVersion
+	snap.ID = s.lastID + 1
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "tmp-"+filePrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(snap.ID)); err != nil {
+		return err
+	}
+	s.lastID = snap.ID
+
+	return s.prune()
+}
+
+// Latest returns the most recent snapshot that can be read, or nil if none
+// has been written. Unreadable snapshots are logged and skipped for older
+// ones; if none of them can be read, that is an error.
+func (s *Store) Latest() (*Snapshot, error) {
+	ids, err := s.list()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	var newest error
+	for i := len(ids) - 1; i >= 0; i-- {
+		snap, err := s.read(ids[i])
+		if err == nil {
+			return snap, nil
+		}
+		s.logger.Error("Skipping unreadable snapshot", zap.Uint64("id", ids[i]), zap.Error(err))
+		if newest == nil {
+			newest = err
+		}
+	}
+	return nil, fmt.Errorf("no readable snapshot: %w", newest)
+}
+
+func (s *Store) read(id uint64) (*Snapshot, error) {
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("decode snapshot %d: %w", id, err)
+	}
+	if snap.Version != formatVersion {
+		return nil, fmt.Errorf("snapshot %d has format version %d, want %d", id, snap.Version, formatVersion)
+	}
+	return &snap, nil
+}
+
+func (s *Store) list() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		var id uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), "%d", &id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (s *Store) prune() error {
+	ids, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	for len(ids) > s.retain {
+		if err := os.Remove(s.path(ids[0])); err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+func (s *Store) path(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", filePrefix, id, fileSuffix))
+}
@@ -0,0 +1,62 @@
+package snapshot
+
+import (
+	"os"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestLatestSkipsUnreadableSnapshots(t *testing.T) {
+	store, err := NewStore(t.TempDir(), 3, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset := int64(10); offset <= 30; offset += 10 {
+		if err := store.Save(&Snapshot{Offsets: map[int]int64{0: offset}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snap, err := store.Latest()
+	if err != nil || snap.ID != 3 || snap.Offsets[0] != 30 {
+		t.Fatalf("got %+v, %v; want snapshot 3", snap, err)
+	}
+
+	// A torn newest file and an old format are both passed over.
+	if err := os.WriteFile(store.path(3), []byte(`{"version":8,"id":3,"offs`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.path(2), []byte(`{"version":1,"id":2}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	snap, err = store.Latest()
+	if err != nil || snap.ID != 1 || snap.Offsets[0] != 10 {
+		t.Fatalf("got %+v, %v; want snapshot 1", snap, err)
+	}
+
+	if err := os.WriteFile(store.path(1), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if snap, err := store.Latest(); err == nil {
+		t.Fatalf("got %+v with every snapshot unreadable", snap)
+	}
+
+	// Numbering carries on past the unreadable snapshots.
+	if err := store.Save(&Snapshot{Offsets: map[int]int64{0: 40}}); err != nil {
+		t.Fatal(err)
+	}
+	if snap, err := store.Latest(); err != nil || snap.ID != 4 {
+		t.Fatalf("got %+v, %v; want snapshot 4", snap, err)
+	}
+}
+
+func TestLatestWithoutSnapshots(t *testing.T) {
+	store, err := NewStore(t.TempDir(), 3, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap, err := store.Latest(); snap != nil || err != nil {
+		t.Fatalf("got %+v, %v; want nothing", snap, err)
+	}
+}
@@ -0,0 +1,292 @@
+// Package wire converts between the commands and events on Kafka and the
+// matcher's types. The engine and the replay tool both go through it, so a
+// replay reads and writes exactly what the engine does.
+package wire
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/opencode-exchange/matching-engine/internal/kafka"
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+	"github.com/shopspring/decimal"
+)
+
+// ParseOrder builds the order a NEW command places, with prices and
+// quantities in scale.
+func ParseOrder(scale orderbook.Scale, cmd *kafka.OrderCommand) (*orderbook.Order, error) {
+	payloadBytes, _ := json.Marshal(cmd.Payload)
+	var payload kafka.NewOrderPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return nil, Reject(matcher.ReasonMalformedCommand, fmt.Errorf("parse payload: %w", err))
+	}
+
+	var side orderbook.Side
+	switch payload.Side {
+	case "BUY":
+		side = orderbook.Buy
+	case "SELL":
+		side = orderbook.Sell
+	default:
+		return nil, Reject(matcher.ReasonInvalidOrder, fmt.Errorf("unknown side %q", payload.Side))
+	}
+
+	var orderType orderbook.OrderType
+	switch payload.OrderType {
+	case "LIMIT":
+		orderType = orderbook.Limit
+	case "MARKET":
+		orderType = orderbook.Market
+	case "STOP_MARKET":
+		orderType = orderbook.StopMarket
+	case "STOP_LIMIT":
+		orderType = orderbook.StopLimit
+	case "TAKE_PROFIT":
+		orderType = orderbook.TakeProfit
+	default:
+		return nil, Reject(matcher.ReasonInvalidOrder, fmt.Errorf("unknown order type %q", payload.OrderType))
+	}
+
+	var price int64
+	if orderType.Triggered() == orderbook.Limit {
+		if payload.Price == nil {
+			return nil, Reject(matcher.ReasonInvalidPrice, fmt.Errorf("limit order without price"))
+		}
+		ticks, err := parseTicks(scale, "price", *payload.Price, matcher.ReasonInvalidPrice)
+		if err != nil {
+			return nil, err
+		}
+		price = ticks
+	}
+
+	var stopPrice int64
+	if orderType.IsConditional() {
+		if payload.StopPrice == nil {
+			return nil, Reject(matcher.ReasonInvalidStopPrice, fmt.Errorf("%s order without stopPrice", payload.OrderType))
+		}
+		ticks, err := parseTicks(scale, "stopPrice", *payload.StopPrice, matcher.ReasonInvalidStopPrice)
+		if err != nil {
+			return nil, err
+		}
+		stopPrice = ticks
+	}
+
+	var quantity int64
+	var quoteQty orderbook.Quote
+	if payload.QuoteQuantity != nil {
+		if payload.Quantity != "" {
+			return nil, Reject(matcher.ReasonInvalidOrder, fmt.Errorf("both quantity and quoteQuantity given"))
+		}
+		quoteDec, err := decimal.NewFromString(*payload.QuoteQuantity)
+		if err != nil {
+			return nil, Reject(matcher.ReasonInvalidQuantity, fmt.Errorf("parse quoteQuantity: %w", err))
+		}
+		if quoteQty, err = scale.ToQuote(quoteDec); err != nil {
+			return nil, Reject(matcher.ReasonInvalidQuantity, err)
+		}
+		if quoteQty.IsZero() {
+			return nil, Reject(matcher.ReasonInvalidQuantity, fmt.Errorf("quoteQuantity must be positive"))
+		}
+	} else {
+		lots, err := parseLots(scale, "quantity", payload.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		quantity = lots
+	}
+
+	var displayQty int64
+	if payload.DisplayQuantity != nil {
+		lots, err := parseLots(scale, "displayQuantity", *payload.DisplayQuantity)
+		if err != nil {
+			return nil, err
+		}
+		if displayQty = lots; displayQty <= 0 {
+			return nil, Reject(matcher.ReasonInvalidQuantity, fmt.Errorf("displayQuantity must be positive"))
+		}
+	}
+
+	timeInForce := orderbook.GTC
+	if payload.TimeInForce != nil {
+		tif, err := orderbook.ParseTimeInForce(*payload.TimeInForce)
+		if err != nil {
+			return nil, Reject(matcher.ReasonInvalidOrder, err)
+		}
+		timeInForce = tif
+	}
+
+	stp := orderbook.STPDefault
+	if payload.STP != nil {
+		mode, err := orderbook.ParseSelfTradePrevention(*payload.STP)
+		if err != nil {
+			return nil, Reject(matcher.ReasonInvalidOrder, err)
+		}
+		stp = mode
+	}
+
+	var maxSlippageBps int64
+	if payload.MaxSlippageBps != nil {
+		if *payload.MaxSlippageBps < 0 {
+			return nil, Reject(matcher.ReasonInvalidOrder, fmt.Errorf("negative maxSlippageBps"))
+		}
+		maxSlippageBps = *payload.MaxSlippageBps
+	}
+
+	order := orderbook.NewOrder(
+		cmd.OrderID,
+		cmd.UserID,
+		cmd.Symbol,
+		side,
+		orderType,
+		price,
+		quantity,
+	)
+	order.TimeInForce = timeInForce
+	order.STP = stp
+	order.QuoteQty = quoteQty
+	order.MaxSlippageBps = maxSlippageBps
+	order.StopPrice = stopPrice
+	order.DisplayQty = displayQty
+
+	return order, nil
+}
+
+// ParseAmend returns the new price and quantity of an AMEND or REPLACE
+// command. Either is 0 if it does not change.
+func ParseAmend(scale orderbook.Scale, cmd *kafka.OrderCommand) (price, quantity int64, err error) {
+	payloadBytes, _ := json.Marshal(cmd.Payload)
+	var payload kafka.AmendOrderPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return 0, 0, Reject(matcher.ReasonMalformedCommand, fmt.Errorf("parse payload: %w", err))
+	}
+	if payload.Price == nil && payload.Quantity == nil {
+		return 0, 0, Reject(matcher.ReasonInvalidOrder, fmt.Errorf("%s without price or quantity", cmd.Type))
+	}
+
+	if payload.Price != nil {
+		ticks, err := parseTicks(scale, "price", *payload.Price, matcher.ReasonInvalidPrice)
+		if err != nil {
+			return 0, 0, err
+		}
+		price = ticks
+	}
+	if payload.Quantity != nil {
+		lots, err := parseLots(scale, "quantity", *payload.Quantity)
+		if err != nil {
+			return 0, 0, err
+		}
+		quantity = lots
+	}
+
+	return price, quantity, nil
+}
+
+// ParseCancelAll returns the orders a CANCEL_ALL command cancels.
+func ParseCancelAll(cmd *kafka.OrderCommand) (matcher.CancelFilter, error) {
+	payloadBytes, _ := json.Marshal(cmd.Payload)
+	var payload kafka.CancelAllPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return matcher.CancelFilter{}, Reject(matcher.ReasonMalformedCommand, fmt.Errorf("parse payload: %w", err))
+	}
+
+	// Without a user or a symbol this would empty every book.
+	if cmd.UserID == "" && cmd.Symbol == "" {
+		return matcher.CancelFilter{}, Reject(matcher.ReasonInvalidOrder, fmt.Errorf("CANCEL_ALL without userId or symbol"))
+	}
+
+	filter := matcher.CancelFilter{UserID: cmd.UserID, Symbol: cmd.Symbol}
+	if payload.Side != nil {
+		var side orderbook.Side
+		switch *payload.Side {
+		case "BUY":
+			side = orderbook.Buy
+		case "SELL":
+			side = orderbook.Sell
+		default:
+			return matcher.CancelFilter{}, Reject(matcher.ReasonInvalidOrder, fmt.Errorf("unknown side %q", *payload.Side))
+		}
+		filter.Side = &side
+	}
+
+	return filter, nil
+}
+
+// ParseHeartbeat returns the timeout of a HEARTBEAT command in milliseconds.
+// 0 disarms the user's switch.
+func ParseHeartbeat(cmd *kafka.OrderCommand) (int64, error) {
+	payloadBytes, _ := json.Marshal(cmd.Payload)
+	var payload kafka.HeartbeatPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return 0, Reject(matcher.ReasonMalformedCommand, fmt.Errorf("parse payload: %w", err))
+	}
+	if cmd.UserID == "" {
+		return 0, Reject(matcher.ReasonInvalidOrder, fmt.Errorf("HEARTBEAT without userId"))
+	}
+	if payload.TimeoutMs < 0 {
+		return 0, Reject(matcher.ReasonInvalidOrder, fmt.Errorf("negative timeoutMs"))
+	}
+	return payload.TimeoutMs, nil
+}
+
+// parseTicks converts a price field to ticks. Prices finer than the scale
+// are rejected with TICK_SIZE, anything else unparseable with reason.
+func parseTicks(scale orderbook.Scale, field, value, reason string) (int64, error) {
+	dec, err := decimal.NewFromString(value)
+	if err != nil {
+		return 0, Reject(reason, fmt.Errorf("parse %s: %w", field, err))
+	}
+	ticks, err := scale.ToTicks(dec)
+	if errors.Is(err, orderbook.ErrPrecision) {
+		return 0, Reject(matcher.ReasonTickSize, err)
+	} else if err != nil {
+		return 0, Reject(reason, err)
+	}
+	return ticks, nil
+}
+
+// parseLots converts a quantity field to lots. Quantities finer than the
+// scale are rejected with STEP_SIZE.
+func parseLots(scale orderbook.Scale, field, value string) (int64, error) {
+	dec, err := decimal.NewFromString(value)
+	if err != nil {
+		return 0, Reject(matcher.ReasonInvalidQuantity, fmt.Errorf("parse %s: %w", field, err))
+	}
+	lots, err := scale.ToLots(dec)
+	if errors.Is(err, orderbook.ErrPrecision) {
+		return 0, Reject(matcher.ReasonStepSize, err)
+	} else if err != nil {
+		return 0, Reject(matcher.ReasonInvalidQuantity, err)
+	}
+	return lots, nil
+}
+
+// rejection is an error caused by the command itself, with the reason
+// reported on its REJECTED execution report.
+type rejection struct {
+	reason string
+	err    error
+}
+
+// Reject marks err as caused by the command, to be reported with reason.
+func Reject(reason string, err error) error {
+	return &rejection{reason: reason, err: err}
+}
+
+// RejectReason returns the reason err was marked with by Reject, if any.
+func RejectReason(err error) (string, bool) {
+	var r *rejection
+	if errors.As(err, &r) {
+		return r.reason, true
+	}
+	return "", false
+}
+
+func (r *rejection) Error() string {
+	return r.err.Error()
+}
+
+func (r *rejection) Unwrap() error {
+	return r.err
+}
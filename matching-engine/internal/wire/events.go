@@ -0,0 +1,141 @@
+package wire
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/opencode-exchange/matching-engine/internal/kafka"
+	"github.com/opencode-exchange/matching-engine/internal/matcher"
+	"github.com/opencode-exchange/matching-engine/internal/orderbook"
+)
+
+// EncodeResult converts result from ticks and lots to decimal strings using
+// scale and encodes it in the order it is published: trades, order updates,
+// then the book delta.
+func EncodeResult(scale orderbook.Scale, result *matcher.MatchResult) ([]kafka.OutboundMessage, error) {
+	var msgs []kafka.OutboundMessage
+	add := func(topic, key string, event interface{}) error {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.OutboundMessage{Topic: topic, Key: key, Value: value})
+		return nil
+	}
+
+	for _, t := range result.Trades {
+		err := add(kafka.TopicTrades, t.Symbol, &kafka.TradeEvent{
+			TradeID:       t.ID,
+			Symbol:        t.Symbol,
+			Price:         scale.Price(t.Price).String(),
+			Quantity:      scale.Qty(t.Quantity).String(),
+			QuoteQty:      scale.Quote(t.QuoteQty).String(),
+			MakerOrderID:  t.MakerOrderID,
+			TakerOrderID:  t.TakerOrderID,
+			MakerUserID:   t.MakerUserID,
+			TakerUserID:   t.TakerUserID,
+			IsBuyerMaker:  t.IsBuyerMaker,
+			MakerFee:      t.MakerFee.Amount.String(),
+			MakerFeeAsset: t.MakerFee.Asset,
+			TakerFee:      t.TakerFee.Amount.String(),
+			TakerFeeAsset: t.TakerFee.Asset,
+			ExecutedAt:    t.ExecutedAt.UnixMilli(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, u := range result.OrderUpdates {
+		err := add(kafka.TopicOrderUpdates, u.Symbol, &kafka.OrderUpdateEvent{
+			OrderID:      u.OrderID,
+			UserID:       u.UserID,
+			Symbol:       u.Symbol,
+			Status:       u.Status,
+			FilledQty:    scale.Qty(u.FilledQty).String(),
+			RemainingQty: scale.Qty(u.RemainingQty).String(),
+			AvgPrice:     scale.AvgPrice(u.FilledQuote, u.FilledQty).String(),
+			Reason:       u.Reason,
+			Timestamp:    u.UpdatedAt.UnixMilli(),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if result.OrderbookDelta != nil {
+		err := add(kafka.TopicOrderbookUpdates, result.OrderbookDelta.Symbol, &kafka.OrderbookUpdateEvent{
+			Symbol:       result.OrderbookDelta.Symbol,
+			PrevSequence: result.OrderbookDelta.PrevSequence,
+			Sequence:     result.OrderbookDelta.Sequence,
+			Bids:         FormatLevels(scale, result.OrderbookDelta.Bids),
+			Asks:         FormatLevels(scale, result.OrderbookDelta.Asks),
+			Timestamp:    result.OrderbookDelta.Timestamp,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return msgs, nil
+}
+
+// FormatLevels converts [price, volume] levels to decimal strings.
+func FormatLevels(scale orderbook.Scale, levels [][2]int64) [][2]string {
+	if levels == nil {
+		return nil
+	}
+
+	formatted := make([][2]string, len(levels))
+	for i, level := range levels {
+		formatted[i] = [2]string{scale.Price(level[0]).String(), scale.Qty(level[1]).String()}
+	}
+	return formatted
+}
+
+// EncodeDeadLetter encodes a message that could not be processed for the
+// dead-letter topic, timestamped now. If it was an identifiable new order, a
+// REJECTED report comes first so the order does not stay NEW forever.
+func EncodeDeadLetter(scale orderbook.Scale, dl *kafka.DeadLetter, now time.Time) ([]kafka.OutboundMessage, error) {
+	event := &kafka.DeadLetterEvent{
+		Partition: dl.Partition,
+		Offset:    dl.Offset,
+		Payload:   string(dl.Value),
+		Error:     dl.Err.Error(),
+		Timestamp: now.UnixMilli(),
+	}
+
+	result := &matcher.MatchResult{}
+	if cmd := dl.Command; cmd != nil {
+		event.CommandID = cmd.CommandID
+		event.OrderID = cmd.OrderID
+
+		reason, _ := RejectReason(dl.Err)
+		if errors.Is(dl.Err, kafka.ErrMalformed) {
+			reason = matcher.ReasonMalformedCommand
+		}
+
+		if reason != "" && cmd.Type == "NEW" && cmd.OrderID != "" && cmd.UserID != "" {
+			result.OrderUpdates = append(result.OrderUpdates, &matcher.OrderUpdate{
+				OrderID:   cmd.OrderID,
+				UserID:    cmd.UserID,
+				Symbol:    cmd.Symbol,
+				Status:    matcher.StatusRejected,
+				Reason:    reason,
+				UpdatedAt: now,
+			})
+		}
+	}
+
+	msgs, err := EncodeResult(scale, result)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return append(msgs, kafka.OutboundMessage{Topic: kafka.TopicDeadLetter, Key: string(dl.Key), Value: value}), nil
+}
@@ -1,13 +1,24 @@
-import type { OrderSide, OrderType } from './order.js';
+import type { OrderSide, OrderStatus, OrderType } from './order.js';
 
 export const KAFKA_TOPICS = {
   ORDERS: 'orders',
   TRADES: 'trades',
   ORDERBOOK_UPDATES: 'orderbook-updates',
+  ORDERBOOK_SNAPSHOTS: 'orderbook-snapshots',
+  ORDER_UPDATES: 'order-updates',
+  ORDERS_DLQ: 'orders-dlq',
   BALANCE_UPDATES: 'balance-updates',
 } as const;
 
-export type OrderCommandType = 'NEW' | 'CANCEL';
+export type OrderCommandType =
+  | 'NEW'
+  | 'CANCEL'
+  | 'REPLACE'
+  | 'AMEND'
+  | 'CANCEL_ALL'
+  | 'HEARTBEAT'
+  | 'TICK'
+  | 'SNAPSHOT_REQUEST';
 
 export interface OrderCommand {
   commandId: string;
@@ -16,21 +27,57 @@ export interface OrderCommand {
   symbol: string;
   type: OrderCommandType;
   timestamp: number;
-  payload: NewOrderPayload | CancelOrderPayload;
+  payload:
+    | NewOrderPayload
+    | CancelOrderPayload
+    | AmendOrderPayload
+    | CancelAllPayload
+    | HeartbeatPayload
+    | null;
 }
 
+export type TimeInForce = 'GTC' | 'IOC' | 'FOK' | 'POST_ONLY';
+
+export type SelfTradePrevention =
+  | 'NONE'
+  | 'CANCEL_NEWEST'
+  | 'CANCEL_OLDEST'
+  | 'CANCEL_BOTH'
+  | 'DECREMENT_AND_CANCEL';
+
+export type ConditionalOrderType = 'STOP_MARKET' | 'STOP_LIMIT' | 'TAKE_PROFIT';
+
 export interface NewOrderPayload {
   side: OrderSide;
-  orderType: OrderType;
+  orderType: OrderType | ConditionalOrderType;
   price?: string;
-  quantity: string;
+  stopPrice?: string;
+  quantity?: string;
+  quoteQuantity?: string;
+  displayQuantity?: string;
   clientOrderId?: string;
+  timeInForce?: TimeInForce;
+  selfTradePrevention?: SelfTradePrevention;
+  maxSlippageBps?: number;
 }
 
 export interface CancelOrderPayload {
   reason?: string;
 }
 
+export interface AmendOrderPayload {
+  price?: string;
+  quantity?: string;
+}
+
+export interface CancelAllPayload {
+  side?: OrderSide;
+}
+
+export interface HeartbeatPayload {
+  timeoutMs: number;
+}
+
 export interface TradeEvent {
   tradeId: string;
   symbol: string;
@@ -43,11 +90,48 @@ export interface TradeEvent {
   takerUserId: string;
   isBuyerMaker: boolean;
   makerFee: string;
+  makerFeeAsset: string;
   takerFee: string;
+  takerFeeAsset: string;
   executedAt: number;
 }
 
+export interface OrderUpdateEvent {
+  orderId: string;
+  userId: string;
+  symbol: string;
+  status: OrderStatus;
+  filledQty: string;
+  remainingQty: string;
+  avgPrice: string;
+  reason?: string;
+  timestamp: number;
+}
+
+export interface DeadLetterEvent {
+  commandId?: string;
+  orderId?: string;
+  partition: number;
+  offset: number;
+  payload: string;
+  error: string;
+  timestamp: number;
+}
+
+// sequence is always prevSequence + 1, and prevSequence is the sequence of
+// the symbol's previous update; any other prevSequence means one was missed.
 export interface OrderbookUpdateEvent {
+  symbol: string;
+  prevSequence: number;
+  sequence: number;
+  bids: [string, string][];
+  asks: [string, string][];
+  timestamp: number;
+}
+
+// Full depth of a book, keyed by symbol on a compacted topic. Apply the
+// updates with a higher sequence on top of it.
+export interface OrderbookSnapshotEvent {
   symbol: string;
   sequence: number;
   bids: [string, string][];